@@ -0,0 +1,56 @@
+package memtable
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+)
+
+// 内部键格式: userKey | seq(8字节，大端) | type(1字节，wal.TypePut或wal.TypeDelete)
+// 同一个用户键的多个版本在InternalKeyComparator下相邻，并按序列号从新到旧排列
+const internalKeyTrailer = 8 + 1
+
+var ErrInvalidInternalKey = errors.New("invalid internal key")
+
+// 将用户键、序列号和类型编码为内部键
+func EncodeInternalKey(userKey []byte, seq uint64, kind byte) []byte {
+	key := make([]byte, len(userKey)+internalKeyTrailer)
+	copy(key, userKey)
+	binary.BigEndian.PutUint64(key[len(userKey):], seq)
+	key[len(key)-1] = kind
+	return key
+}
+
+// 解码内部键，返回的userKey与key共享底层数组
+func DecodeInternalKey(key []byte) ([]byte, uint64, byte, error) {
+	if len(key) < internalKeyTrailer {
+		return nil, 0, 0, ErrInvalidInternalKey
+	}
+	n := len(key) - internalKeyTrailer
+	return key[:n], binary.BigEndian.Uint64(key[n:]), key[len(key)-1], nil
+}
+
+// 内部键比较器: 先按用户键的字节序升序，相同时按序列号降序，再按类型降序
+// 键必须是[]byte类型的内部键
+type InternalKeyComparator struct{}
+
+func (cmp InternalKeyComparator) Name() string {
+	return "golsm.InternalKeyComparator"
+}
+
+func (cmp InternalKeyComparator) Compare(a, b interface{}) int {
+	aKey, aOk := a.([]byte)
+	bKey, bOk := b.([]byte)
+
+	if !aOk || !bOk || len(aKey) < internalKeyTrailer || len(bKey) < internalKeyTrailer {
+		panic("InternalKeyComparator: invalid internal key")
+	}
+
+	aN, bN := len(aKey)-internalKeyTrailer, len(bKey)-internalKeyTrailer
+	if c := bytes.Compare(aKey[:aN], bKey[:bN]); c != 0 {
+		return c
+	}
+
+	// 序列号和类型都按降序，直接比较b和a的后缀
+	return bytes.Compare(bKey[bN:], aKey[aN:])
+}
@@ -0,0 +1,73 @@
+package memtable
+
+import (
+	"bytes"
+	"errors"
+	"golsm/src/skiplist"
+	"golsm/src/wal"
+	"strconv"
+	"testing"
+)
+
+func TestInternalKeyRoundTrip(t *testing.T) {
+	for _, userKey := range [][]byte{nil, []byte("k"), []byte("user\x00key")} {
+		key := EncodeInternalKey(userKey, 0x0102030405060708, wal.TypeDelete)
+		got, seq, kind, err := DecodeInternalKey(key)
+		if err != nil || !bytes.Equal(got, userKey) || seq != 0x0102030405060708 || kind != wal.TypeDelete {
+			t.Fatalf("DecodeInternalKey(%q) = %q, %x, %d, %v", key, got, seq, kind, err)
+		}
+	}
+
+	if _, _, _, err := DecodeInternalKey([]byte("short")); !errors.Is(err, ErrInvalidInternalKey) {
+		t.Fatalf("got %v, want ErrInvalidInternalKey", err)
+	}
+}
+
+func TestInternalKeyOrder(t *testing.T) {
+	sl := skiplist.NewSkipList(InternalKeyComparator{})
+	inserts := []struct {
+		userKey string
+		seq     uint64
+		kind    byte
+	}{
+		{"b", 3, wal.TypePut},
+		{"a", 1, wal.TypePut},
+		{"b", 10, wal.TypeDelete},
+		{"a", 7, wal.TypeDelete},
+		{"ab", 2, wal.TypePut},
+		{"b", 1 << 40, wal.TypePut},
+		{"a", 7, wal.TypePut},
+	}
+	for _, in := range inserts {
+		sl.Insert(EncodeInternalKey([]byte(in.userKey), in.seq, in.kind), nil)
+	}
+
+	// 用户键升序，同一个用户键的版本从新到旧，序列号相同时类型降序
+	want := []string{"a/7/2", "a/7/1", "a/1/1", "ab/2/1", "b/1099511627776/1", "b/10/2", "b/3/1"}
+	var got []string
+	for iter := sl.NewIterator(); iter.Valid(); iter.Next() {
+		userKey, seq, kind, err := DecodeInternalKey(iter.Key().([]byte))
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, string(userKey)+"/"+strconv.FormatUint(seq, 10)+"/"+strconv.Itoa(int(kind)))
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("got %v, want %v", got, want)
+		}
+	}
+
+	expectPanic := func() {
+		if recover() == nil {
+			t.Fatal("comparing a short key did not panic")
+		}
+	}
+	func() {
+		defer expectPanic()
+		InternalKeyComparator{}.Compare([]byte("x"), EncodeInternalKey([]byte("x"), 1, wal.TypePut))
+	}()
+}
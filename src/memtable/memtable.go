@@ -1,54 +1,120 @@
 package memtable
 
 import (
+	"errors"
 	"golsm/src/skiplist"
 	"golsm/src/wal"
+	"io"
+	"sync"
+	"time"
 )
 
+// 删除标记，被删除的键在SkipList中保留该值，用于遮盖更早写入的数据
+type tombstone struct{}
+
 // MemTable 结构
+// SkipList中的值为[]byte或tombstone，键的状态转换:
+// Put使不存在或已删除的键变为有效值，或覆盖已有的值；Delete使键变为删除标记
 type MemTable struct {
+	mu       sync.RWMutex // 保护skipList和size，写操作持有写锁直到WAL和SkipList都更新完成
 	skipList *skiplist.SkipList
 	log      *wal.WAL
+	size     int64 // 所有键和有效值的字节数
+	recovery RecoveryStats
+}
+
+// 单次写入的选项
+type WriteOptions struct {
+	// 为true时等待WAL同步到磁盘后才返回；为false时写入WAL后立即返回，进程崩溃不会丢失数据，
+	// 但系统崩溃或断电时可能丢失最近一个后台同步间隔内的写入。创建MemTable时syncWrites为true则总是同步
+	// 同步在MemTable的锁外进行，并发的同步写入合并为一次fsync
+	Sync bool
+}
+
+// 创建MemTable的选项
+type Options struct {
+	SyncWrites bool // 每次写入都同步WAL到磁盘
+
+	// 从WAL恢复时遇到损坏的数据默认返回错误；为true时跳过损坏的记录，继续恢复之后完好的记录，
+	// 跳过的损坏通过RecoveryStats报告
+	SkipCorruption bool
+
+	// 后台同步WAL的间隔，不同步的写入最迟在该时间后同步到磁盘
+	// 0表示使用DefaultSyncInterval，负数表示不在后台同步
+	SyncInterval time.Duration
+}
+
+// 默认的后台同步间隔
+const DefaultSyncInterval = time.Second
+
+// 从WAL恢复的统计
+type RecoveryStats struct {
+	Records     int                    // 恢复的记录数
+	Corruptions []*wal.CorruptionError // 跳过的损坏，只在SkipCorruption为true时可能非空
 }
 
 // 创建新的MemTable
 func New(walPath string, syncWrites bool) (*MemTable, error) {
+	return NewWithOptions(walPath, Options{SyncWrites: syncWrites})
+}
+
+// 使用选项创建新的MemTable
+func NewWithOptions(walPath string, opts Options) (*MemTable, error) {
 	// 打开WAL
-	log, err := wal.Open(walPath, syncWrites)
+	syncInterval := opts.SyncInterval
+	if syncInterval == 0 {
+		syncInterval = DefaultSyncInterval
+	}
+	log, err := wal.OpenWithOptions(walPath, wal.Options{SyncOps: opts.SyncWrites, SyncInterval: syncInterval})
 	if err != nil {
 		return nil, err
 	}
 
 	// 创建SkipList
-	list := skiplist.NewSkipList(nil)
+	m := &MemTable{
+		skipList: skiplist.NewSkipList(skiplist.BytesComparator{}),
+		log:      log,
+	}
 
 	// 从WAL恢复数据
-	iter, err := log.NewIterator()
-	if err != nil {
+	if err := m.recover(opts.SkipCorruption); err != nil {
 		log.Close()
 		return nil, err
 	}
+	return m, nil
+}
+
+// 迭代WAL中的所有记录并重建MemTable
+func (m *MemTable) recover(skipCorruption bool) error {
+	iter, err := m.log.NewIterator()
+	if err != nil {
+		return err
+	}
 	defer iter.Close()
 
-	// 迭代WAL中的所有记录并重建MemTable
 	for {
 		record, err := iter.Next()
+		if err == io.EOF {
+			return nil
+		}
+		var corruption *wal.CorruptionError
+		if skipCorruption && errors.As(err, &corruption) {
+			// 迭代器已经跳过损坏的数据，继续读取之后的记录
+			m.recovery.Corruptions = append(m.recovery.Corruptions, corruption)
+			continue
+		}
 		if err != nil {
-			break
+			return err
 		}
 
-		switch record.Type {
-		case wal.TypePut:
-			list.Insert(record.Key, record.Value)
-		case wal.TypeDelete:
-			list.Delete(record.Key)
-		}
+		m.apply(record.Type, record.Key, record.Value)
+		m.recovery.Records++
 	}
+}
 
-	return &MemTable{
-		skipList: list,
-		log:      log,
-	}, nil
+// 返回创建MemTable时从WAL恢复的统计
+func (m *MemTable) RecoveryStats() RecoveryStats {
+	return m.recovery
 }
 
 // 关闭MemTable
@@ -56,8 +122,111 @@ func (m *MemTable) Close() error {
 	return m.log.Close()
 }
 
+// 查找键对应的值，返回值的副本
+func (m *MemTable) Get(key []byte) ([]byte, bool) {
+	return m.GetInto(key, nil)
+}
+
+// 查找键对应的值并复制到dst中，dst容量不足时才分配新的空间
+// 返回的切片归调用方所有，容量足够时与dst共享底层数组，MemTable不会保留对它的引用
+func (m *MemTable) GetInto(key, dst []byte) ([]byte, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	value, found := m.find(key)
+	if !found {
+		return nil, false
+	}
+	return append(dst[:0], value...), true
+}
+
+// 返回MemTable占用内存的估计值: 所有键(包括删除标记的键)和有效值的字节数
+func (m *MemTable) ApproximateSize() int64 {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.size
+}
+
+// 按键的顺序对每一条记录调用fn，包括删除标记(deleted为true，value为nil)，fn返回false时停止
+// 遍历期间持有读锁，fn不能修改或保留key和value，也不能再调用MemTable的写方法
+func (m *MemTable) ForEach(fn func(key, value []byte, deleted bool) bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for iter := m.skipList.NewIterator(); iter.Valid(); iter.Next() {
+		value, live := iter.Value().([]byte)
+		if !fn(iter.Key().([]byte), value, !live) {
+			return
+		}
+	}
+}
+
+// 查找键对应的有效值，键不存在或已被删除时返回false，调用方需持有m.mu
+func (m *MemTable) find(key []byte) ([]byte, bool) {
+	value, found := m.skipList.Find(key)
+	if !found {
+		return nil, false
+	}
+	live, ok := value.([]byte)
+	return live, ok
+}
+
+// 将一条记录应用到SkipList并更新大小，调用方需持有m.mu
+func (m *MemTable) apply(recordType byte, key, value []byte) {
+	var newValue interface{} = tombstone{}
+	if recordType == wal.TypePut {
+		newValue = value
+		m.size += int64(len(value))
+	}
+
+	// 一次插入同时得到被覆盖的值，顺序写入时不需要从头查找
+	old, found := m.skipList.InsertAndReturn(key, newValue)
+	if !found {
+		m.size += int64(len(key))
+	} else if oldValue, ok := old.([]byte); ok {
+		m.size -= int64(len(oldValue))
+	}
+}
+
 // 插入键值对
 func (m *MemTable) Put(key, value []byte) error {
+	return m.PutWithOptions(key, value, WriteOptions{})
+}
+
+// 使用写入选项插入键值对
+func (m *MemTable) PutWithOptions(key, value []byte, opts WriteOptions) error {
+	m.mu.Lock()
+	err := m.put(key, value)
+	end := m.log.End()
+	m.mu.Unlock()
+
+	if err != nil || !opts.Sync {
+		return err
+	}
+	// 在锁外等待同步，写入在同步完成之前就已经对读取可见
+	return m.log.SyncTo(end)
+}
+
+// 条件插入: 读取当前值并调用pred，仅当pred返回true时写入，返回是否写入
+// 读取、判断和写入在同一把锁内完成，是原子的；pred不能修改或保留old，也不能再调用MemTable的方法
+func (m *MemTable) PutIf(key, value []byte, pred func(old []byte, existed bool) bool) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	old, existed := m.find(key)
+	if !pred(old, existed) {
+		return false, nil
+	}
+
+	if err := m.put(key, value); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// 写入键值对，调用方需持有m.mu
+func (m *MemTable) put(key, value []byte) error {
 	// 先写WAL
 	err := m.log.Write(wal.Record{
 		Type:  wal.TypePut,
@@ -69,12 +238,76 @@ func (m *MemTable) Put(key, value []byte) error {
 	}
 
 	// 再更新SkipList
-	m.skipList.Insert(key, value)
+	m.apply(wal.TypePut, key, value)
 	return nil
 }
 
-// 删除键
+// 批量写入记录，先作为一个批量写入WAL，再应用到SkipList
+// 同一个键出现多次时以最后一条记录为准，只有最后一条会更新SkipList；
+// coalesce为true时在写入WAL之前就合并重复的键，WAL中只保留每个键的最后一条记录
+func (m *MemTable) WriteBatch(records []wal.Record, coalesce bool) error {
+	for _, record := range records {
+		if record.Type != wal.TypePut && record.Type != wal.TypeDelete {
+			return wal.ErrInvalidRecord
+		}
+	}
+
+	// 每个键最后一条记录的位置，BytesComparator相等即字节相同，可以直接用作map的键
+	last := make(map[string]int, len(records))
+	for i, record := range records {
+		last[string(record.Key)] = i
+	}
+	final := records
+	if len(last) < len(records) {
+		final = make([]wal.Record, 0, len(last))
+		for i, record := range records {
+			if last[string(record.Key)] == i {
+				final = append(final, record)
+			}
+		}
+	}
+
+	logged := records
+	if coalesce {
+		logged = final
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// 先写WAL
+	if err := m.log.WriteBatch(logged); err != nil {
+		return err
+	}
+
+	// 再更新SkipList
+	for _, record := range final {
+		m.apply(record.Type, record.Key, record.Value)
+	}
+	return nil
+}
+
+// 删除键，SkipList中保留删除标记
 func (m *MemTable) Delete(key []byte) error {
+	return m.DeleteWithOptions(key, WriteOptions{})
+}
+
+// 使用写入选项删除键
+func (m *MemTable) DeleteWithOptions(key []byte, opts WriteOptions) error {
+	m.mu.Lock()
+	err := m.delete(key)
+	end := m.log.End()
+	m.mu.Unlock()
+
+	if err != nil || !opts.Sync {
+		return err
+	}
+	// 在锁外等待同步，写入在同步完成之前就已经对读取可见
+	return m.log.SyncTo(end)
+}
+
+// 删除键，调用方需持有m.mu
+func (m *MemTable) delete(key []byte) error {
 	// 先写WAL
 	err := m.log.Write(wal.Record{
 		Type:  wal.TypeDelete,
@@ -86,6 +319,6 @@ func (m *MemTable) Delete(key []byte) error {
 	}
 
 	// 再更新SkipList
-	m.skipList.Delete(key)
+	m.apply(wal.TypeDelete, key, nil)
 	return nil
 }
@@ -0,0 +1,584 @@
+package memtable
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"golsm/src/skiplist"
+	"golsm/src/wal"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func open(t *testing.T, path string) *MemTable {
+	t.Helper()
+
+	m, err := New(path, false)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	return m
+}
+
+func key(i int) []byte {
+	return []byte(fmt.Sprintf("key-%06d", i))
+}
+
+// 修改文件中offset处的一个字节
+func flipByte(t *testing.T, path string, offset int64) {
+	t.Helper()
+
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	b := make([]byte, 1)
+	if _, err := f.ReadAt(b, offset); err != nil {
+		t.Fatal(err)
+	}
+	b[0] ^= 0xFF
+	if _, err := f.WriteAt(b, offset); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRecoverCorruptBlock(t *testing.T) {
+	const blockSize = 32 * 1024
+	path := filepath.Join(t.TempDir(), "wal")
+
+	// 写入跨越多个块的记录，并记下每条记录在WAL中的起始位置
+	m := open(t, path)
+	offsets := make([]int64, 3000)
+	for i := range offsets {
+		offsets[i] = m.log.End()
+		if err := m.Put(key(i), []byte(fmt.Sprintf("value-%d", i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := m.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// 破坏第二个块中间的一条记录
+	bad := 0
+	for offsets[bad] < blockSize+blockSize/2 {
+		bad++
+	}
+	flipByte(t, path, offsets[bad]+7+1)
+
+	// 默认遇到损坏时返回错误
+	_, err := New(path, false)
+	var corruption *wal.CorruptionError
+	if !errors.As(err, &corruption) || corruption.Offset != offsets[bad] {
+		t.Fatalf("got %v, want *wal.CorruptionError at offset %d", err, offsets[bad])
+	}
+
+	// 跳过损坏的记录，同一个块和之后块中的记录仍然恢复
+	m, err = NewWithOptions(path, Options{SkipCorruption: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+
+	recovered := 0
+	for i := range offsets {
+		value, found := m.Get(key(i))
+		if i == bad {
+			if found {
+				t.Fatalf("corrupted key %d was recovered", bad)
+			}
+			continue
+		}
+		if !found || string(value) != fmt.Sprintf("value-%d", i) {
+			t.Fatalf("key %d at offset %d: got %q, %v", i, offsets[i], value, found)
+		}
+		recovered++
+	}
+
+	stats := m.RecoveryStats()
+	if stats.Records != recovered || len(stats.Corruptions) != 1 || stats.Corruptions[0].Offset != offsets[bad] {
+		t.Fatalf("got stats %+v, want %d records and one corruption at offset %d", stats, recovered, offsets[bad])
+	}
+}
+
+func TestRecoverUnrecognizedLog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "wal")
+
+	// 分块格式之前的日志: type | keyLen | valueLen | key | value | crc32
+	var old []byte
+	for i := 0; i < 10; i++ {
+		k, v := key(i), []byte("value")
+		record := append([]byte{wal.TypePut, byte(len(k)), byte(len(v))}, k...)
+		record = append(record, v...)
+		old = binary.LittleEndian.AppendUint32(record, crc32.ChecksumIEEE(record))
+	}
+	if err := os.WriteFile(path, old, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// 不能被当作空的MemTable打开，之后的写入会覆盖旧的记录
+	_, err := New(path, false)
+	var corruption *wal.CorruptionError
+	if !errors.As(err, &corruption) {
+		t.Fatalf("got %v, want *wal.CorruptionError", err)
+	}
+	if data, _ := os.ReadFile(path); string(data) != string(old) {
+		t.Fatal("unrecognized log was modified")
+	}
+}
+
+// 只有一条记录且已损坏的日志按损坏处理，不当作无法识别的格式
+func TestRecoverCorruptFirstRecord(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "wal")
+	m := open(t, path)
+	if err := m.Put(key(0), []byte("value")); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Close(); err != nil {
+		t.Fatal(err)
+	}
+	flipByte(t, path, 7+1)
+
+	m, err := NewWithOptions(path, Options{SkipCorruption: true})
+	if err != nil {
+		t.Fatalf("NewWithOptions: %v", err)
+	}
+	defer m.Close()
+	if _, found := m.Get(key(0)); found {
+		t.Fatal("corrupted key was recovered")
+	}
+	if err := m.Put(key(1), []byte("value")); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGetInto(t *testing.T) {
+	m := open(t, filepath.Join(t.TempDir(), "wal"))
+	defer m.Close()
+
+	value := []byte("0123456789abcdef")
+	if err := m.Put([]byte("k"), value); err != nil {
+		t.Fatal(err)
+	}
+
+	// 容量足够时复用dst，除了查找本身(键转换为interface{})之外不分配内存
+	k := []byte("k")
+	lookup := testing.AllocsPerRun(100, func() {
+		m.skipList.Find(k)
+	})
+	dst := make([]byte, 0, 64)
+	allocs := testing.AllocsPerRun(100, func() {
+		dst, _ = m.GetInto(k, dst)
+	})
+	if allocs > lookup {
+		t.Fatalf("GetInto with a large dst allocated %v times, lookup alone %v", allocs, lookup)
+	}
+	if string(dst) != string(value) || &dst[:1][0] != &dst[:cap(dst)][0] {
+		t.Fatalf("got %q, want %q in the caller's buffer", dst, value)
+	}
+
+	// 容量不足时分配新的切片，dst不被修改
+	small := []byte("xyz")
+	got, found := m.GetInto([]byte("k"), small[:0:2])
+	if !found || string(got) != string(value) || string(small) != "xyz" {
+		t.Fatalf("got %q, %v with small dst %q", got, found, small)
+	}
+
+	// 返回的切片归调用方所有，修改它不影响MemTable
+	got[0] = 'X'
+	if again, _ := m.Get([]byte("k")); string(again) != string(value) {
+		t.Fatalf("memtable value changed to %q", again)
+	}
+
+	if got, found := m.GetInto([]byte("missing"), dst); found || got != nil {
+		t.Fatalf("missing key: got %q, %v", got, found)
+	}
+}
+
+func TestRecoverPreallocatedLog(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "wal")
+
+	// 开启预分配的WAL崩溃后留下末尾全为0的文件
+	log, err := wal.OpenWithOptions(path, wal.Options{PreallocateSize: 1 << 20})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 100; i++ {
+		if err := log.Write(wal.Record{Type: wal.TypePut, Key: key(i), Value: key(i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	log.Close()
+	crashed := filepath.Join(dir, "crashed")
+	if err := os.WriteFile(crashed, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// 恢复后写入新的记录，再次恢复时新旧记录都在
+	m := open(t, crashed)
+	for i := 100; i < 200; i++ {
+		if err := m.Put(key(i), key(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	m.Close()
+
+	m = open(t, crashed)
+	defer m.Close()
+	for i := 0; i < 200; i++ {
+		if value, found := m.Get(key(i)); !found || string(value) != string(key(i)) {
+			t.Fatalf("key %d: got %q, %v", i, value, found)
+		}
+	}
+	if stats := m.RecoveryStats(); stats.Records != 200 || len(stats.Corruptions) != 0 {
+		t.Fatalf("got stats %+v, want 200 records", stats)
+	}
+}
+
+func TestPutIf(t *testing.T) {
+	m := open(t, filepath.Join(t.TempDir(), "wal"))
+	defer m.Close()
+
+	// 键不存在时只在pred允许时写入
+	written, err := m.PutIf([]byte("k"), []byte("v1"), func(old []byte, existed bool) bool {
+		if existed || old != nil {
+			t.Errorf("new key: got old %q, existed %v", old, existed)
+		}
+		return true
+	})
+	if err != nil || !written {
+		t.Fatalf("got %v, %v, want written", written, err)
+	}
+
+	// pred返回false时既不修改MemTable也不写WAL
+	end := m.log.End()
+	written, err = m.PutIf([]byte("k"), []byte("v2"), func(old []byte, existed bool) bool {
+		if !existed || string(old) != "v1" {
+			t.Errorf("existing key: got old %q, existed %v", old, existed)
+		}
+		return false
+	})
+	if err != nil || written {
+		t.Fatalf("got %v, %v, want not written", written, err)
+	}
+	if m.log.End() != end {
+		t.Fatalf("rejected PutIf wrote %d bytes to the WAL", m.log.End()-end)
+	}
+	if value, _ := m.Get([]byte("k")); string(value) != "v1" {
+		t.Fatalf("got %q, want v1", value)
+	}
+
+	// 比较并交换
+	cas := func(expected, value string) bool {
+		written, err := m.PutIf([]byte("k"), []byte(value), func(old []byte, existed bool) bool {
+			return existed && string(old) == expected
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return written
+	}
+	if cas("v2", "v3") || !cas("v1", "v3") {
+		t.Fatal("compare-and-swap took the wrong branch")
+	}
+	if value, _ := m.Get([]byte("k")); string(value) != "v3" {
+		t.Fatalf("got %q, want v3", value)
+	}
+}
+
+func TestTombstoneTransitions(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "wal")
+	m := open(t, path)
+
+	check := func(step string, want string, found bool, size int64) {
+		t.Helper()
+		value, ok := m.Get([]byte("key"))
+		if ok != found || string(value) != want {
+			t.Fatalf("%s: got %q, %v, want %q, %v", step, value, ok, want, found)
+		}
+		if got := m.ApproximateSize(); got != size {
+			t.Fatalf("%s: size %d, want %d", step, got, size)
+		}
+	}
+
+	// 键占3字节，删除标记保留键但不计值
+	check("empty", "", false, 0)
+	m.Delete([]byte("key"))
+	check("delete missing", "", false, 3)
+	m.Put([]byte("key"), []byte("12345"))
+	check("tombstone to live", "12345", true, 8)
+	m.Put([]byte("key"), []byte("12"))
+	check("live to live", "12", true, 5)
+	m.Delete([]byte("key"))
+	check("live to tombstone", "", false, 3)
+	m.Delete([]byte("key"))
+	check("tombstone to tombstone", "", false, 3)
+	m.Put([]byte("key"), []byte("1234"))
+	check("tombstone to live again", "1234", true, 7)
+
+	// 从WAL恢复后状态和大小相同
+	m.Close()
+	m = open(t, path)
+	defer m.Close()
+	check("recovered", "1234", true, 7)
+}
+
+func TestForEach(t *testing.T) {
+	m := open(t, filepath.Join(t.TempDir(), "wal"))
+	defer m.Close()
+
+	for _, i := range []int{5, 1, 9, 3, 7} {
+		m.Put(key(i), []byte(strconv.Itoa(i)))
+	}
+	m.Delete(key(3))
+	m.Delete(key(4))
+
+	// 按键的顺序遍历，包括删除标记
+	type entry struct {
+		key, value string
+		deleted    bool
+	}
+	var got []entry
+	m.ForEach(func(k, v []byte, deleted bool) bool {
+		got = append(got, entry{string(k), string(v), deleted})
+		return true
+	})
+	want := []entry{
+		{string(key(1)), "1", false},
+		{string(key(3)), "", true},
+		{string(key(4)), "", true},
+		{string(key(5)), "5", false},
+		{string(key(7)), "7", false},
+		{string(key(9)), "9", false},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("got %v, want %v", got, want)
+		}
+	}
+
+	// fn返回false时停止
+	calls := 0
+	m.ForEach(func(k, v []byte, deleted bool) bool {
+		calls++
+		return calls < 3
+	})
+	if calls != 3 {
+		t.Fatalf("ForEach called fn %d times after it returned false, want 3", calls)
+	}
+}
+
+// 统计WAL中的记录数
+func logged(t *testing.T, m *MemTable) int {
+	t.Helper()
+
+	n := 0
+	err := m.log.Scan(func(record *wal.Record, offset int64, err error) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		n++
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func TestWriteBatchDuplicateKeys(t *testing.T) {
+	batch := []wal.Record{
+		{Type: wal.TypePut, Key: []byte("a"), Value: []byte("1")},
+		{Type: wal.TypePut, Key: []byte("b"), Value: []byte("1")},
+		{Type: wal.TypeDelete, Key: []byte("a")},
+		{Type: wal.TypePut, Key: []byte("b"), Value: []byte("22")},
+	}
+
+	for _, coalesce := range []bool{false, true} {
+		path := filepath.Join(t.TempDir(), "wal")
+		m := open(t, path)
+		m.Put([]byte("a"), []byte("old"))
+
+		if err := m.WriteBatch(batch, coalesce); err != nil {
+			t.Fatal(err)
+		}
+
+		// 同一个键以最后一条记录为准，先写入再删除的键变为删除标记
+		check := func(m *MemTable) {
+			t.Helper()
+			if _, found := m.Get([]byte("a")); found {
+				t.Fatalf("coalesce=%v: deleted key a is visible", coalesce)
+			}
+			if value, _ := m.Get([]byte("b")); string(value) != "22" {
+				t.Fatalf("coalesce=%v: got b=%q, want 22", coalesce, value)
+			}
+			deleted := false
+			m.ForEach(func(key, value []byte, isDeleted bool) bool {
+				if string(key) == "a" {
+					deleted = isDeleted
+				}
+				return true
+			})
+			if !deleted {
+				t.Fatalf("coalesce=%v: key a has no tombstone", coalesce)
+			}
+			if size := m.ApproximateSize(); size != 1+1+2 {
+				t.Fatalf("coalesce=%v: size %d, want 4", coalesce, size)
+			}
+		}
+		check(m)
+
+		// 合并时WAL中每个键只保留最后一条记录
+		want := 1 + len(batch)
+		if coalesce {
+			want = 1 + 2
+		}
+		if n := logged(t, m); n != want {
+			t.Fatalf("coalesce=%v: logged %d records, want %d", coalesce, n, want)
+		}
+
+		// 恢复后结果相同
+		m.Close()
+		m = open(t, path)
+		check(m)
+		m.Close()
+	}
+}
+
+// 统计比较次数的比较器
+type countingComparator struct {
+	skiplist.BytesComparator
+	calls int
+}
+
+func (cmp *countingComparator) Compare(a, b interface{}) int {
+	cmp.calls++
+	return cmp.BytesComparator.Compare(a, b)
+}
+
+// 顺序写入走SkipList末尾的快速路径，不需要从头查找
+func TestPutSequentialComparisons(t *testing.T) {
+	m := open(t, filepath.Join(t.TempDir(), "wal"))
+	defer m.Close()
+
+	cmp := &countingComparator{}
+	m.skipList = skiplist.NewSkipList(cmp)
+	const n = 1000
+	for i := 0; i < n; i++ {
+		if err := m.Put(key(i), []byte("value")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if cmp.calls > 2*n {
+		t.Fatalf("%d comparisons for %d sequential puts", cmp.calls, n)
+	}
+	if size := m.ApproximateSize(); size != n*int64(len(key(0))+len("value")) {
+		t.Fatalf("got size %d", size)
+	}
+}
+
+func BenchmarkPutSequential(b *testing.B) {
+	m, err := New(filepath.Join(b.TempDir(), "wal"), false)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer m.Close()
+
+	value := []byte("value")
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := m.Put([]byte(fmt.Sprintf("key-%012d", i)), value); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// 不同步的写入由后台同步到磁盘，不依赖之后的同步写入
+func TestBackgroundSync(t *testing.T) {
+	m, err := NewWithOptions(filepath.Join(t.TempDir(), "wal"), Options{SyncInterval: 10 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+
+	if err := m.Put(key(0), []byte("value")); err != nil {
+		t.Fatal(err)
+	}
+	end := m.log.End()
+	deadline := time.Now().Add(5 * time.Second)
+	for m.log.Synced() < end {
+		if time.Now().After(deadline) {
+			t.Fatalf("synced %d, want %d", m.log.Synced(), end)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestWriteOptionsSync(t *testing.T) {
+	// 关闭后台同步，确定不同步的写入没有被同步
+	m, err := NewWithOptions(filepath.Join(t.TempDir(), "wal"), Options{SyncInterval: -1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+
+	// 不同步的写入在fsync之前返回
+	if err := m.Put(key(1), []byte("relaxed")); err != nil {
+		t.Fatal(err)
+	}
+	if synced, end := m.log.Synced(), m.log.End(); synced >= end {
+		t.Fatalf("relaxed Put synced the WAL (%d of %d)", synced, end)
+	}
+
+	// 同步的写入返回时之前的所有写入都已经同步
+	if err := m.PutWithOptions(key(2), []byte("strict"), WriteOptions{Sync: true}); err != nil {
+		t.Fatal(err)
+	}
+	if synced, end := m.log.Synced(), m.log.End(); synced != end {
+		t.Fatalf("strict Put returned with %d of %d bytes synced", synced, end)
+	}
+	if err := m.DeleteWithOptions(key(1), WriteOptions{Sync: true}); err != nil {
+		t.Fatal(err)
+	}
+	if synced, end := m.log.Synced(), m.log.End(); synced != end {
+		t.Fatalf("strict Delete returned with %d of %d bytes synced", synced, end)
+	}
+
+	// 并发的同步写入
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 50; i++ {
+				if err := m.PutWithOptions(key(1000+g*50+i), []byte("v"), WriteOptions{Sync: true}); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+	if synced, end := m.log.Synced(), m.log.End(); synced != end {
+		t.Fatalf("%d of %d bytes synced after concurrent strict writes", synced, end)
+	}
+	for i := 1000; i < 1400; i++ {
+		if _, found := m.Get(key(i)); !found {
+			t.Fatalf("key %d missing", i)
+		}
+	}
+}
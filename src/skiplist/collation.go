@@ -0,0 +1,47 @@
+package skiplist
+
+import (
+	"golang.org/x/text/collate"
+	"golang.org/x/text/language"
+)
+
+// 按语言区域排序规则比较字符串的比较器，例如带重音符号的字符排在对应的基本字符附近，
+// 而不是按原始字节排在所有ASCII字符之后
+// 键必须是合法的UTF-8字符串。排序规则认为相等的不同字符串再按字节比较，保证不同的键不会相等
+// collate.Collator不是并发安全的，比较器不能被多个goroutine同时使用
+type CollationComparator struct {
+	collator *collate.Collator
+	tag      language.Tag
+}
+
+// 创建指定语言区域的比较器
+func NewCollationComparator(tag language.Tag) *CollationComparator {
+	return &CollationComparator{
+		collator: collate.New(tag),
+		tag:      tag,
+	}
+}
+
+func (cmp *CollationComparator) Name() string {
+	return "golsm.CollationComparator/" + cmp.tag.String()
+}
+
+func (cmp *CollationComparator) Compare(a, b interface{}) int {
+	aStr, aOk := a.(string)
+	bStr, bOk := b.(string)
+
+	if !aOk || !bOk {
+		panic("CollationComparator: invalid type")
+	}
+
+	if c := cmp.collator.CompareString(aStr, bStr); c != 0 {
+		return c
+	}
+
+	if aStr < bStr {
+		return -1
+	} else if aStr > bStr {
+		return 1
+	}
+	return 0
+}
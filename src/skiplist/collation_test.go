@@ -0,0 +1,43 @@
+package skiplist
+
+import (
+	"testing"
+
+	"golang.org/x/text/language"
+)
+
+func TestCollationComparator(t *testing.T) {
+	cmp := NewCollationComparator(language.English)
+
+	sl := NewSkipList(cmp)
+	for _, k := range []string{"zebra", "éclair", "apple", "Eclair", "eclair", "Zoo", "ångström", "banana"} {
+		sl.Insert(k, nil)
+	}
+	keys, _ := collect(sl)
+
+	// 按字节比较时带重音符号的字符排在所有ASCII字符之后，按排序规则则排在基本字符附近
+	want := []interface{}{"ångström", "apple", "banana", "eclair", "Eclair", "éclair", "zebra", "Zoo"}
+	if len(keys) != len(want) {
+		t.Fatalf("got %v, want %v", keys, want)
+	}
+	for i := range want {
+		if keys[i] != want[i] {
+			t.Fatalf("got %v, want %v", keys, want)
+		}
+	}
+	if (StringComparator{}).Compare("éclair", "zebra") <= 0 {
+		t.Fatal("byte order unexpectedly puts éclair before zebra")
+	}
+
+	// 排序规则认为相等的不同字符串按字节区分，不会被当作同一个键
+	if cmp.Compare("á", "á") == 0 {
+		t.Fatal("different encodings of á compared equal")
+	}
+	if cmp.Compare("apple", "apple") != 0 {
+		t.Fatal("identical keys compared unequal")
+	}
+
+	if NewCollationComparator(language.Swedish).Name() == cmp.Name() {
+		t.Fatal("comparators for different locales share a name")
+	}
+}
@@ -1,8 +1,17 @@
 package skiplist
 
+import (
+	"bytes"
+	"strconv"
+)
+
 // 整数比较器
 type IntComparator struct{}
 
+func (cmp IntComparator) Name() string {
+	return "golsm.IntComparator"
+}
+
 func (cmp IntComparator) Compare(a, b interface{}) int {
 	aInt, aOk := a.(int)
 	bInt, bOk := b.(int)
@@ -19,9 +28,77 @@ func (cmp IntComparator) Compare(a, b interface{}) int {
 	return 0
 }
 
+// 数值比较器，接受任意宽度的整数类型(int、int8...int64、uint、uint8...uint64、uintptr)
+// 有符号整数统一转换为int64比较，无符号整数统一转换为uint64比较，因此int(1)和int64(1)视为同一个键
+// 同时出现有符号和无符号整数通常意味着键的来源不一致，直接panic
+type NumericComparator struct{}
+
+func (cmp NumericComparator) Name() string {
+	return "golsm.NumericComparator"
+}
+
+func (cmp NumericComparator) Compare(a, b interface{}) int {
+	aInt, aSigned := toInteger(a)
+	bInt, bSigned := toInteger(b)
+
+	if aSigned != bSigned {
+		panic("NumericComparator: mixing signed and unsigned integers")
+	}
+
+	if aSigned {
+		x, y := int64(aInt), int64(bInt)
+		if x < y {
+			return -1
+		} else if x > y {
+			return 1
+		}
+		return 0
+	}
+
+	if aInt < bInt {
+		return -1
+	} else if aInt > bInt {
+		return 1
+	}
+	return 0
+}
+
+// 将整数转换为uint64表示，同时返回是否为有符号类型
+func toInteger(v interface{}) (uint64, bool) {
+	switch x := v.(type) {
+	case int:
+		return uint64(x), true
+	case int8:
+		return uint64(x), true
+	case int16:
+		return uint64(x), true
+	case int32:
+		return uint64(x), true
+	case int64:
+		return uint64(x), true
+	case uint:
+		return uint64(x), false
+	case uint8:
+		return uint64(x), false
+	case uint16:
+		return uint64(x), false
+	case uint32:
+		return uint64(x), false
+	case uint64:
+		return x, false
+	case uintptr:
+		return uint64(x), false
+	}
+	panic("NumericComparator: invalid type")
+}
+
 // 字符串比较器
 type StringComparator struct{}
 
+func (cmp StringComparator) Name() string {
+	return "golsm.StringComparator"
+}
+
 func (cmp StringComparator) Compare(a, b interface{}) int {
 	aStr, aOk := a.(string)
 	bStr, bOk := b.(string)
@@ -41,6 +118,10 @@ func (cmp StringComparator) Compare(a, b interface{}) int {
 // 字节数组比较器(常用于LSM树中的键比较)
 type BytesComparator struct{}
 
+func (cmp BytesComparator) Name() string {
+	return "golsm.BytesComparator"
+}
+
 func (cmp BytesComparator) Compare(a, b interface{}) int {
 	aBytes, aOk := a.([]byte)
 	bBytes, bOk := b.([]byte)
@@ -70,3 +151,93 @@ func (cmp BytesComparator) Compare(a, b interface{}) int {
 	}
 	return 0
 }
+
+// 组合比较器: 先按primary升序比较键的第一部分，相等时按secondary降序比较第二部分
+// split将键拆分为两部分，例如排行榜的键为(分组, 分数)时，同一分组内分数高的排在前面:
+//
+//	ThenByDesc(StringComparator{}, IntComparator{}, func(key interface{}) (interface{}, interface{}) {
+//		entry := key.(ScoreKey)
+//		return entry.Group, entry.Score
+//	})
+type ThenByDescComparator struct {
+	primary   Comparator
+	secondary Comparator
+	split     func(key interface{}) (interface{}, interface{})
+}
+
+func ThenByDesc(primary, secondary Comparator, split func(key interface{}) (interface{}, interface{})) *ThenByDescComparator {
+	return &ThenByDescComparator{
+		primary:   primary,
+		secondary: secondary,
+		split:     split,
+	}
+}
+
+func (cmp *ThenByDescComparator) Name() string {
+	return "golsm.ThenByDesc(" + cmp.primary.Name() + "," + cmp.secondary.Name() + ")"
+}
+
+func (cmp *ThenByDescComparator) Compare(a, b interface{}) int {
+	aFirst, aSecond := cmp.split(a)
+	bFirst, bSecond := cmp.split(b)
+
+	if c := cmp.primary.Compare(aFirst, bFirst); c != 0 {
+		return c
+	}
+	// 交换参数实现降序，不对结果取反，避免比较器返回最小的int时溢出
+	return cmp.secondary.Compare(bSecond, aSecond)
+}
+
+// 带时间戳后缀的键比较器，键为[]byte，最后Width个字节是大端编码的无符号时间戳
+// 先按去掉时间戳的用户键升序，相同时按时间戳排序，Descending为true时新的时间戳在前
+// 键的长度不能小于Width
+type SuffixTimestampComparator struct {
+	Width      int
+	Descending bool
+}
+
+func (cmp SuffixTimestampComparator) Name() string {
+	order := "asc"
+	if cmp.Descending {
+		order = "desc"
+	}
+	return "golsm.SuffixTimestampComparator/" + strconv.Itoa(cmp.Width) + "/" + order
+}
+
+func (cmp SuffixTimestampComparator) Compare(a, b interface{}) int {
+	aBytes, aOk := a.([]byte)
+	bBytes, bOk := b.([]byte)
+
+	if !aOk || !bOk {
+		panic("SuffixTimestampComparator: invalid type")
+	}
+	if cmp.Width <= 0 || len(aBytes) < cmp.Width || len(bBytes) < cmp.Width {
+		panic("SuffixTimestampComparator: key shorter than timestamp width")
+	}
+
+	aN, bN := len(aBytes)-cmp.Width, len(bBytes)-cmp.Width
+	if c := bytes.Compare(aBytes[:aN], bBytes[:bN]); c != 0 {
+		return c
+	}
+
+	// 宽度相同的大端无符号整数，字节序与数值大小一致
+	if cmp.Descending {
+		return bytes.Compare(bBytes[bN:], aBytes[aN:])
+	}
+	return bytes.Compare(aBytes[aN:], bBytes[bN:])
+}
+
+// 计算前缀扫描的上界: 返回大于所有以prefix开头的键的最小键
+// 做法是去掉末尾的0xFF字节后将最后一个字节加1，例如"ab\xff"的结果为"ac"
+// prefix为空或全部为0xFF时不存在这样的键，返回nil表示无上界
+func BytesSuccessor(prefix []byte) []byte {
+	for i := len(prefix) - 1; i >= 0; i-- {
+		if prefix[i] != 0xFF {
+			succ := make([]byte, i+1)
+			copy(succ, prefix[:i+1])
+			succ[i]++
+			return succ
+		}
+	}
+	return nil
+}
@@ -0,0 +1,218 @@
+package skiplist
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"testing"
+)
+
+func TestBytesSuccessor(t *testing.T) {
+	cases := []struct {
+		prefix []byte
+		want   []byte
+	}{
+		{[]byte("abc"), []byte("abd")},
+		{[]byte("ab\xff"), []byte("ac")},
+		{[]byte("a\xff\xff"), []byte("b")},
+		{[]byte("\x00"), []byte("\x01")},
+		{[]byte("a\xfe\xff"), []byte("a\xff")},
+		{[]byte("\xff"), nil},
+		{[]byte("\xff\xff\xff"), nil},
+		{[]byte{}, nil},
+		{nil, nil},
+	}
+	for _, c := range cases {
+		got := BytesSuccessor(c.prefix)
+		if !bytes.Equal(got, c.want) || (got == nil) != (c.want == nil) {
+			t.Errorf("BytesSuccessor(%q) = %q, want %q", c.prefix, got, c.want)
+		}
+	}
+
+	// 结果大于所有以prefix开头的键，且不修改prefix
+	cmp := BytesComparator{}
+	prefix := []byte("k\x01\xff")
+	succ := BytesSuccessor(prefix)
+	if !bytes.Equal(prefix, []byte("k\x01\xff")) {
+		t.Fatalf("prefix modified to %q", prefix)
+	}
+	for _, suffix := range []string{"", "\x00", "\xff\xff\xff\xff", "zzz"} {
+		key := append(append([]byte{}, prefix...), suffix...)
+		if cmp.Compare(key, succ) >= 0 {
+			t.Errorf("%q is not below successor %q", key, succ)
+		}
+	}
+}
+
+func TestComparatorNamesDistinct(t *testing.T) {
+	comparators := []Comparator{
+		IntComparator{},
+		NumericComparator{},
+		StringComparator{},
+		BytesComparator{},
+	}
+
+	seen := map[string]bool{}
+	for _, cmp := range comparators {
+		name := cmp.Name()
+		if name == "" || seen[name] {
+			t.Errorf("comparator %T has empty or duplicate name %q", cmp, name)
+		}
+		seen[name] = true
+	}
+}
+
+func TestNumericComparator(t *testing.T) {
+	cmp := NumericComparator{}
+
+	cases := []struct {
+		a, b interface{}
+		want int
+	}{
+		{int(1), int32(2), -1},
+		{int64(-5), int(3), -1},
+		{int32(-1), int64(-1), 0},
+		{int8(100), int16(-100), 1},
+		{int64(math.MinInt64), int32(math.MinInt32), -1},
+		{int64(math.MaxInt64), int(0), 1},
+		{uint(7), uint64(7), 0},
+		{uint8(255), uint32(256), -1},
+		{uint64(math.MaxUint64), uint(1), 1},
+	}
+	for _, c := range cases {
+		if got := sign(cmp.Compare(c.a, c.b)); got != c.want {
+			t.Errorf("Compare(%T(%v), %T(%v)) = %d, want %d", c.a, c.a, c.b, c.b, got, c.want)
+		}
+	}
+
+	// 不同宽度的有符号整数混合插入，按数值排序，数值相同的视为同一个键
+	sl := NewSkipList(cmp)
+	for _, k := range []interface{}{int64(30), int(-10), int32(20), int(0), int64(-10), int32(5)} {
+		sl.Insert(k, nil)
+	}
+	keys, _ := collect(sl)
+	want := []int64{-10, 0, 5, 20, 30}
+	if len(keys) != len(want) {
+		t.Fatalf("got %v, want %v", keys, want)
+	}
+	for i, k := range keys {
+		if v, _ := toInteger(k); int64(v) != want[i] {
+			t.Fatalf("got %v, want %v", keys, want)
+		}
+	}
+
+	expectPanic(t, "NumericComparator: mixing signed and unsigned integers", func() { cmp.Compare(int(1), uint(1)) })
+	expectPanic(t, "NumericComparator: invalid type", func() { cmp.Compare(int(1), "1") })
+}
+
+func sign(c int) int {
+	if c < 0 {
+		return -1
+	} else if c > 0 {
+		return 1
+	}
+	return 0
+}
+
+type scoreKey struct {
+	Group string
+	Score int
+}
+
+func TestThenByDesc(t *testing.T) {
+	cmp := ThenByDesc(StringComparator{}, IntComparator{}, func(key interface{}) (interface{}, interface{}) {
+		entry := key.(scoreKey)
+		return entry.Group, entry.Score
+	})
+
+	sl := NewSkipList(cmp)
+	for _, k := range []scoreKey{{"b", 10}, {"a", 5}, {"b", 30}, {"a", 50}, {"c", 1}, {"a", 20}, {"b", 30}} {
+		sl.Insert(k, nil)
+	}
+
+	// 分组升序，同一分组内分数降序，完全相同的键只保留一个
+	keys, _ := collect(sl)
+	want := []scoreKey{{"a", 50}, {"a", 20}, {"a", 5}, {"b", 30}, {"b", 10}, {"c", 1}}
+	if len(keys) != len(want) {
+		t.Fatalf("got %v, want %v", keys, want)
+	}
+	for i := range want {
+		if keys[i] != want[i] {
+			t.Fatalf("got %v, want %v", keys, want)
+		}
+	}
+
+	// 降序不依赖对结果取反
+	extreme := ThenByDesc(StringComparator{}, minIntComparator{}, func(key interface{}) (interface{}, interface{}) {
+		return "", key
+	})
+	if extreme.Compare(1, 2) <= 0 {
+		t.Fatal("secondary order was not reversed")
+	}
+
+	if cmp.Name() == ThenByDesc(StringComparator{}, StringComparator{}, nil).Name() {
+		t.Fatal("different secondary comparators share a name")
+	}
+}
+
+// 小于时返回math.MinInt的比较器，取反会溢出
+type minIntComparator struct{}
+
+func (minIntComparator) Name() string { return "minInt" }
+
+func (minIntComparator) Compare(a, b interface{}) int {
+	if a.(int) < b.(int) {
+		return math.MinInt
+	} else if a.(int) > b.(int) {
+		return 1
+	}
+	return 0
+}
+
+// 用户键加上width字节大端编码的时间戳
+func timestampKey(userKey string, ts uint64, width int) []byte {
+	var buf [8]byte
+	binary.BigEndian.PutUint64(buf[:], ts)
+	return append([]byte(userKey), buf[8-width:]...)
+}
+
+func TestSuffixTimestampComparator(t *testing.T) {
+	type entry struct {
+		userKey string
+		ts      uint64
+	}
+	entries := []entry{{"b", 5}, {"a", 300}, {"a", 2}, {"ab", 1}, {"b", 70000}, {"a", 1 << 24}}
+
+	for _, width := range []int{4, 8} {
+		for _, descending := range []bool{false, true} {
+			cmp := SuffixTimestampComparator{Width: width, Descending: descending}
+			sl := NewSkipList(cmp)
+			for _, e := range entries {
+				sl.Insert(timestampKey(e.userKey, e.ts, width), e)
+			}
+
+			// 用户键升序，同一个用户键按时间戳排序
+			want := []entry{{"a", 2}, {"a", 300}, {"a", 1 << 24}, {"ab", 1}, {"b", 5}, {"b", 70000}}
+			if descending {
+				want = []entry{{"a", 1 << 24}, {"a", 300}, {"a", 2}, {"ab", 1}, {"b", 70000}, {"b", 5}}
+			}
+			_, values := collect(sl)
+			if len(values) != len(want) {
+				t.Fatalf("width %d descending %v: got %v, want %v", width, descending, values, want)
+			}
+			for i := range want {
+				if values[i] != want[i] {
+					t.Fatalf("width %d descending %v: got %v, want %v", width, descending, values, want)
+				}
+			}
+		}
+	}
+
+	cmp := SuffixTimestampComparator{Width: 4}
+	expectPanic(t, "SuffixTimestampComparator: key shorter than timestamp width", func() {
+		cmp.Compare([]byte("abc"), timestampKey("a", 1, 4))
+	})
+	if cmp.Name() == (SuffixTimestampComparator{Width: 8}).Name() || cmp.Name() == (SuffixTimestampComparator{Width: 4, Descending: true}).Name() {
+		t.Fatal("comparators with different orders share a name")
+	}
+}
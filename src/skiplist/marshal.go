@@ -0,0 +1,119 @@
+package skiplist
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// 序列化格式: 节点数(uvarint)，之后按键的顺序依次为
+// 键长度(uvarint) | 键 | 值长度(uvarint) | 值
+// 键和值的编码由调用方提供，与WAL的二进制格式无关
+
+// 将跳表中的所有键值对按顺序写入w，keyEnc和valEnc负责将键和值编码为字节
+func (sl *SkipList) Marshal(w io.Writer, keyEnc, valEnc func(interface{}) ([]byte, error)) error {
+	var lenBuf [binary.MaxVarintLen64]byte
+	writeBytes := func(data []byte) error {
+		n := binary.PutUvarint(lenBuf[:], uint64(len(data)))
+		if _, err := w.Write(lenBuf[:n]); err != nil {
+			return err
+		}
+		_, err := w.Write(data)
+		return err
+	}
+
+	n := binary.PutUvarint(lenBuf[:], uint64(sl.size))
+	if _, err := w.Write(lenBuf[:n]); err != nil {
+		return err
+	}
+
+	for x := sl.head.forward[0]; x != nil; x = x.forward[0] {
+		key, err := keyEnc(x.key)
+		if err != nil {
+			return err
+		}
+		value, err := valEnc(x.value)
+		if err != nil {
+			return err
+		}
+
+		if err := writeBytes(key); err != nil {
+			return err
+		}
+		if err := writeBytes(value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 从r读取Marshal写入的数据，解码后插入跳表，已有的相同键会被覆盖
+// 只读取Marshal写入的字节，r中之后的数据不受影响
+func (sl *SkipList) Unmarshal(r io.Reader, keyDec, valDec func([]byte) (interface{}, error)) error {
+	br, ok := r.(io.ByteReader)
+	if !ok {
+		br = singleByteReader{r}
+	}
+
+	readBytes := func() ([]byte, error) {
+		n, err := binary.ReadUvarint(br)
+		if err != nil {
+			return nil, unexpectedEOF(err)
+		}
+		// 逐步读取，避免按损坏的长度一次性分配过多内存
+		data, err := io.ReadAll(io.LimitReader(r, int64(n)))
+		if err != nil {
+			return nil, err
+		}
+		if uint64(len(data)) != n {
+			return nil, io.ErrUnexpectedEOF
+		}
+		return data, nil
+	}
+
+	count, err := binary.ReadUvarint(br)
+	if err != nil {
+		return err
+	}
+
+	for i := uint64(0); i < count; i++ {
+		keyData, err := readBytes()
+		if err != nil {
+			return err
+		}
+		valueData, err := readBytes()
+		if err != nil {
+			return err
+		}
+
+		key, err := keyDec(keyData)
+		if err != nil {
+			return err
+		}
+		value, err := valDec(valueData)
+		if err != nil {
+			return err
+		}
+		sl.Insert(key, value)
+	}
+	return nil
+}
+
+// 数据中途结束时返回io.ErrUnexpectedEOF
+func unexpectedEOF(err error) error {
+	if errors.Is(err, io.EOF) {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// 每次只从底层读取一个字节，保证不会读取超出序列化数据的部分
+type singleByteReader struct {
+	r io.Reader
+}
+
+func (b singleByteReader) ReadByte() (byte, error) {
+	var buf [1]byte
+	_, err := io.ReadFull(b.r, buf[:])
+	return buf[0], err
+}
@@ -0,0 +1,97 @@
+package skiplist
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"strconv"
+	"testing"
+)
+
+type point struct {
+	X, Y int
+	Tag  string
+}
+
+func encodeInt(key interface{}) ([]byte, error) {
+	return []byte(strconv.Itoa(key.(int))), nil
+}
+
+func decodeInt(data []byte) (interface{}, error) {
+	return strconv.Atoi(string(data))
+}
+
+func encodePoint(value interface{}) ([]byte, error) {
+	return json.Marshal(value)
+}
+
+func decodePoint(data []byte) (interface{}, error) {
+	var p point
+	err := json.Unmarshal(data, &p)
+	return p, err
+}
+
+// 隐藏bytes.Reader的ReadByte，只提供io.Reader
+type plainReader struct {
+	r io.Reader
+}
+
+func (p plainReader) Read(b []byte) (int, error) {
+	return p.r.Read(b)
+}
+
+func TestMarshalRoundTrip(t *testing.T) {
+	sl := NewSkipList(IntComparator{})
+	for _, k := range randomKeys(500, 10000) {
+		sl.Insert(k, point{X: k, Y: -k, Tag: "p" + strconv.Itoa(k)})
+	}
+
+	var buf bytes.Buffer
+	if err := sl.Marshal(&buf, encodeInt, encodePoint); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+
+	// 之后还有其他数据时，Unmarshal不能读取超出序列化数据的部分
+	for name, r := range map[string]io.Reader{
+		"ByteReader": bytes.NewReader(append(append([]byte{}, data...), "trailer"...)),
+		"Reader":     plainReader{bytes.NewReader(append(append([]byte{}, data...), "trailer"...))},
+	} {
+		loaded := NewSkipList(IntComparator{})
+		if err := loaded.Unmarshal(r, decodeInt, decodePoint); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+
+		wantKeys, wantValues := collect(sl)
+		keys, values := collect(loaded)
+		if len(keys) != len(wantKeys) {
+			t.Fatalf("%s: got %d entries, want %d", name, len(keys), len(wantKeys))
+		}
+		for i := range keys {
+			if keys[i] != wantKeys[i] || values[i] != wantValues[i] {
+				t.Fatalf("%s: entry %d: got %v=%v, want %v=%v", name, i, keys[i], values[i], wantKeys[i], wantValues[i])
+			}
+		}
+
+		rest, _ := io.ReadAll(r)
+		if string(rest) != "trailer" {
+			t.Fatalf("%s: left %q unread, want trailer", name, rest)
+		}
+	}
+
+	// 数据中途结束
+	for _, n := range []int{1, len(data) / 2, len(data) - 1} {
+		err := NewSkipList(IntComparator{}).Unmarshal(bytes.NewReader(data[:n]), decodeInt, decodePoint)
+		if !errors.Is(err, io.ErrUnexpectedEOF) {
+			t.Fatalf("truncated to %d bytes: got %v, want ErrUnexpectedEOF", n, err)
+		}
+	}
+
+	// 编码器的错误原样返回
+	failed := errors.New("cannot encode")
+	err := sl.Marshal(io.Discard, encodeInt, func(interface{}) ([]byte, error) { return nil, failed })
+	if err != failed {
+		t.Fatalf("got %v, want the encoder's error", err)
+	}
+}
@@ -0,0 +1,65 @@
+package skiplist
+
+// 有序映射，对SkipList的简单封装，隐藏比较器和迭代器的细节
+// 适用于只需要按键排序的映射的场景，不是并发安全的
+type OrderedMap struct {
+	list *SkipList
+}
+
+// 使用指定的比较器创建有序映射
+func NewOrderedMap(cmp Comparator) *OrderedMap {
+	return &OrderedMap{list: NewSkipList(cmp)}
+}
+
+// 创建键为int的有序映射
+func NewIntMap() *OrderedMap {
+	return NewOrderedMap(IntComparator{})
+}
+
+// 创建键为string的有序映射
+func NewStringMap() *OrderedMap {
+	return NewOrderedMap(StringComparator{})
+}
+
+// 创建键为[]byte的有序映射，映射会保留键的切片，调用方之后不能再修改它
+func NewBytesMap() *OrderedMap {
+	return NewOrderedMap(BytesComparator{})
+}
+
+// 设置键对应的值，键已存在时覆盖
+func (m *OrderedMap) Set(key, value interface{}) {
+	m.list.Insert(key, value)
+}
+
+// 获取键对应的值
+func (m *OrderedMap) Get(key interface{}) (interface{}, bool) {
+	return m.list.Find(key)
+}
+
+// 删除键，返回键是否存在
+func (m *OrderedMap) Delete(key interface{}) bool {
+	return m.list.Delete(key)
+}
+
+// 返回键值对的数量
+func (m *OrderedMap) Len() int {
+	return m.list.Size()
+}
+
+// 按键的顺序对每个键值对调用fn，fn返回false时停止，遍历期间不能修改映射
+func (m *OrderedMap) Range(fn func(key, value interface{}) bool) {
+	for x := m.list.head.forward[0]; x != nil; x = x.forward[0] {
+		if !fn(x.key, x.value) {
+			return
+		}
+	}
+}
+
+// 返回按顺序排列的所有键
+func (m *OrderedMap) Keys() []interface{} {
+	keys := make([]interface{}, 0, m.list.Size())
+	for x := m.list.head.forward[0]; x != nil; x = x.forward[0] {
+		keys = append(keys, x.key)
+	}
+	return keys
+}
@@ -0,0 +1,90 @@
+package skiplist
+
+import (
+	"math/rand"
+	"sort"
+	"strconv"
+	"testing"
+)
+
+func TestOrderedMap(t *testing.T) {
+	m := NewStringMap()
+	reference := map[string]int{}
+
+	// 随机操作，与map加排序的结果对照
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 5000; i++ {
+		k := strconv.Itoa(r.Intn(300))
+		switch r.Intn(3) {
+		case 0, 1:
+			m.Set(k, i)
+			reference[k] = i
+		case 2:
+			_, existed := reference[k]
+			if m.Delete(k) != existed {
+				t.Fatalf("Delete(%q) disagrees with map", k)
+			}
+			delete(reference, k)
+		}
+
+		want, existed := reference[k]
+		if value, found := m.Get(k); found != existed || (found && value != want) {
+			t.Fatalf("Get(%q) = %v, %v, want %v, %v", k, value, found, want, existed)
+		}
+	}
+
+	want := make([]string, 0, len(reference))
+	for k := range reference {
+		want = append(want, k)
+	}
+	sort.Strings(want)
+
+	if m.Len() != len(want) {
+		t.Fatalf("Len() = %d, want %d", m.Len(), len(want))
+	}
+	keys := m.Keys()
+	if len(keys) != len(want) {
+		t.Fatalf("Keys() returned %d keys, want %d", len(keys), len(want))
+	}
+	i := 0
+	m.Range(func(key, value interface{}) bool {
+		if keys[i] != want[i] || key != want[i] || value != reference[want[i]] {
+			t.Fatalf("entry %d: Keys %v, Range %v=%v, want %s=%d", i, keys[i], key, value, want[i], reference[want[i]])
+		}
+		i++
+		return true
+	})
+	if i != len(want) {
+		t.Fatalf("Range visited %d entries, want %d", i, len(want))
+	}
+
+	// Range在fn返回false时停止
+	visited := 0
+	m.Range(func(key, value interface{}) bool {
+		visited++
+		return false
+	})
+	if visited != 1 {
+		t.Fatalf("Range visited %d entries after stop, want 1", visited)
+	}
+}
+
+func TestOrderedMapKeyTypes(t *testing.T) {
+	ints := NewIntMap()
+	for _, k := range []int{3, -1, 2} {
+		ints.Set(k, nil)
+	}
+	if keys := ints.Keys(); keys[0] != -1 || keys[1] != 2 || keys[2] != 3 {
+		t.Fatalf("int keys %v", keys)
+	}
+
+	bytesMap := NewBytesMap()
+	bytesMap.Set([]byte("b"), 1)
+	bytesMap.Set([]byte("a"), 2)
+	if value, _ := bytesMap.Get([]byte("a")); value != 2 {
+		t.Fatalf("got %v, want 2", value)
+	}
+	if keys := bytesMap.Keys(); string(keys[0].([]byte)) != "a" {
+		t.Fatalf("bytes keys %q", keys)
+	}
+}
@@ -1,17 +1,18 @@
 package skiplist
 
 import (
-	"math/rand"
+	"math/bits"
 	"time"
 )
 
 const (
-	maxLevel    = 32
-	probability = 0.25
+	maxLevel  = 32
+	levelBits = 2 // 每升高一层需要连续levelBits个随机位为0，即概率为1/4
 )
 
 type Comparator interface {
 	Compare(a, b interface{}) int // 返回负数表示a<b, 0表示a=b，正数代表a>b
+	Name() string                 // 比较器名称，排序规则不同的比较器必须使用不同的名称
 }
 
 // Node 跳表节点
@@ -22,11 +23,21 @@ type Node struct {
 }
 
 type SkipList struct {
-	head       *Node
+	head       *Node // head.forward随level增长，长度不小于level
 	comparator Comparator
 	level      int
 	size       int
-	r          *rand.Rand
+	rng        uint64 // 生成随机层数的xorshift64*状态，不能为0
+	modCount   uint64 // 结构修改(插入新节点、删除节点)的次数，用于迭代器检测并发修改
+
+	// 每层的最后一个节点，最近一次插入的节点是最后一个节点时有效，长度为0表示无效
+	// 键按递增顺序插入时直接接在这些节点之后，省去从head开始的查找
+	tail []*Node
+
+	// 被删除节点的空闲链表，按层数分组并通过forward[0]串联，插入时优先复用
+	freeNodes [maxLevel]*Node
+	freeCount int
+	poolSize  int // 空闲链表最多保存的节点数，0表示不复用
 }
 
 func NewSkipList(cmp Comparator) *SkipList {
@@ -34,21 +45,92 @@ func NewSkipList(cmp Comparator) *SkipList {
 		panic("Comparator can not be nil")
 	}
 
+	// head的前向指针按需增长，大量小跳表时不必每个都分配maxLevel个指针
 	head := &Node{
-		forward: make([]*Node, maxLevel),
+		forward: make([]*Node, 1),
 	}
 	return &SkipList{
 		head:       head,
 		comparator: cmp,
 		level:      1,
-		r:          rand.New(rand.NewSource(time.Now().UnixNano())),
+		rng:        uint64(time.Now().UnixNano()) | 1,
 	}
 }
 
+// 设置被删除节点的复用池大小，删除频繁的场景下可以减少内存分配，0或负数表示关闭复用
+// 节点被复用后，仍停留在该节点上的迭代器会读到新的键值，需要重新Seek
+func (sl *SkipList) SetNodePoolSize(n int) {
+	if n < 0 {
+		n = 0
+	}
+	sl.poolSize = n
+	for sl.freeCount > n {
+		released := false
+		for i := range sl.freeNodes {
+			if x := sl.freeNodes[i]; x != nil {
+				sl.freeNodes[i] = x.forward[0]
+				x.forward[0] = nil
+				sl.freeCount--
+				released = true
+				break
+			}
+		}
+		if !released {
+			// 计数与空闲链表不一致时以链表为准，避免死循环
+			sl.freeCount = 0
+			break
+		}
+	}
+}
+
+// 创建节点，优先复用空闲链表中层数相同的节点
+func (sl *SkipList) newNode(key, value interface{}, level int) *Node {
+	x := sl.freeNodes[level-1]
+	if x == nil {
+		return &Node{
+			key:     key,
+			value:   value,
+			forward: make([]*Node, level),
+		}
+	}
+
+	sl.freeNodes[level-1] = x.forward[0]
+	sl.freeCount--
+	x.key = key
+	x.value = value
+	x.forward[0] = nil
+	return x
+}
+
+// 回收被删除的节点，清空键值和指针以免引用的数据无法被GC
+func (sl *SkipList) freeNode(x *Node) {
+	if sl.freeCount >= sl.poolSize {
+		return
+	}
+
+	level := len(x.forward)
+	x.key = nil
+	x.value = nil
+	for i := range x.forward {
+		x.forward[i] = nil
+	}
+	x.forward[0] = sl.freeNodes[level-1]
+	sl.freeNodes[level-1] = x
+	sl.freeCount++
+}
+
+// 生成随机层数: 取一个随机数末尾连续0的位数，每levelBits位升高一层，
+// 与逐层以1/4概率抛硬币的几何分布相同，但只需要生成一次随机数
 func (sl *SkipList) randomLevel() int {
-	level := 1
-	for level < maxLevel && sl.r.Float64() < probability {
-		level++
+	// xorshift64*
+	sl.rng ^= sl.rng >> 12
+	sl.rng ^= sl.rng << 25
+	sl.rng ^= sl.rng >> 27
+	x := sl.rng * 2685821657736338717
+
+	level := 1 + bits.TrailingZeros64(x)/levelBits
+	if level > maxLevel {
+		level = maxLevel
 	}
 	return level
 }
@@ -69,49 +151,101 @@ func (sl *SkipList) Find(key interface{}) (interface{}, bool) {
 	return nil, false
 }
 
+// 插入键值对，键已存在时覆盖原来的值
 func (sl *SkipList) Insert(key, value interface{}) {
+	sl.insert(key, value, true)
+}
+
+// 仅当键不存在时插入，键已存在时不做修改并返回false
+func (sl *SkipList) InsertUnique(key, value interface{}) bool {
+	_, existed := sl.insert(key, value, false)
+	return !existed
+}
+
+// 插入键值对并返回被覆盖的值，键不存在时返回nil和false
+// 与先Find再Insert相比只查找一次，顺序插入时仍然走末尾的快速路径
+func (sl *SkipList) InsertAndReturn(key, value interface{}) (interface{}, bool) {
+	return sl.insert(key, value, true)
+}
+
+// 插入键值对，键已存在时根据overwrite决定是否覆盖，返回原来的值和键是否已存在
+func (sl *SkipList) insert(key, value interface{}, overwrite bool) (interface{}, bool) {
 	update := make([]*Node, maxLevel)
 	x := sl.head
 
-	for i := sl.level - 1; i >= 0; i-- {
-		for x.forward[i] != nil && sl.comparator.Compare(x.forward[i].key, key) < 0 {
-			x = x.forward[i]
+	if len(sl.tail) > 0 && sl.tail[0] != sl.head && sl.comparator.Compare(key, sl.tail[0].key) > 0 {
+		// 键大于最后一个节点，每层的前驱就是该层的最后一个节点
+		copy(update, sl.tail)
+		x = sl.tail[0]
+	} else {
+		for i := sl.level - 1; i >= 0; i-- {
+			for x.forward[i] != nil && sl.comparator.Compare(x.forward[i].key, key) < 0 {
+				x = x.forward[i]
+			}
+			update[i] = x
 		}
-		update[i] = x
 	}
 
 	// exist
 	x = x.forward[0]
 	if x != nil && sl.comparator.Compare(x.key, key) == 0 {
-		x.value = value
-		return
+		old := x.value
+		if overwrite {
+			x.value = value
+		}
+		return old, true
 	}
 
 	level := sl.randomLevel()
 
 	if level > sl.level {
+		for len(sl.head.forward) < level {
+			sl.head.forward = append(sl.head.forward, nil)
+		}
 		for i := sl.level; i < level; i++ {
 			update[i] = sl.head
 		}
 		sl.level = level
 	}
 
-	newNode := &Node{
-		key:     key,
-		value:   value,
-		forward: make([]*Node, level),
-	}
+	newNode := sl.newNode(key, value, level)
 
 	// put new node to every level
 	for i := 0; i < level; i++ {
 		newNode.forward[i] = update[i].forward[i]
 		update[i].forward[i] = newNode
 	}
+
+	// 新节点是最后一个节点时，它所在的各层以新节点结尾，更高层的最后一个节点仍是update中的前驱
+	sl.tail = sl.tail[:0]
+	if newNode.forward[0] == nil {
+		for i := 0; i < sl.level; i++ {
+			if i < level {
+				sl.tail = append(sl.tail, newNode)
+			} else {
+				sl.tail = append(sl.tail, update[i])
+			}
+		}
+	}
+
 	sl.size++
+	sl.modCount++
+	return nil, false
 }
 
 // 删除键对应的节点
 func (sl *SkipList) Delete(key interface{}) bool {
+	_, existed := sl.delete(key)
+	return existed
+}
+
+// 删除键对应的节点并返回被删除的值，键不存在时返回nil和false
+func (sl *SkipList) DeleteAndReturn(key interface{}) (interface{}, bool) {
+	return sl.delete(key)
+}
+
+// 删除键对应的节点，返回被删除的值和键是否存在
+func (sl *SkipList) delete(key interface{}) (interface{}, bool) {
 	update := make([]*Node, maxLevel)
 	x := sl.head
 
@@ -127,8 +261,9 @@ func (sl *SkipList) Delete(key interface{}) bool {
 
 	// 没找到要删除的节点
 	if x == nil || sl.comparator.Compare(x.key, key) != 0 {
-		return false
+		return nil, false
 	}
+	value := x.value
 
 	// 删除节点
 	for i := 0; i < sl.level; i++ {
@@ -144,7 +279,10 @@ func (sl *SkipList) Delete(key interface{}) bool {
 	}
 
 	sl.size--
-	return true
+	sl.modCount++
+	sl.tail = sl.tail[:0]
+	sl.freeNode(x)
+	return value, true
 }
 
 // 获取跳表大小
@@ -152,21 +290,125 @@ func (sl *SkipList) Size() int {
 	return sl.size
 }
 
+// 返回最小的键，跳表为空时返回false
+func (sl *SkipList) FirstKey() (interface{}, bool) {
+	x := sl.head.forward[0]
+	if x == nil {
+		return nil, false
+	}
+	return x.key, true
+}
+
+// 返回最大的键，跳表为空时返回false
+// 从最高层开始逐层向右走到底，复杂度为O(log n)
+func (sl *SkipList) LastKey() (interface{}, bool) {
+	x := sl.findLast()
+	if x == sl.head {
+		return nil, false
+	}
+	return x.key, true
+}
+
+// 返回最小的键值对但不删除，跳表为空时返回false
+func (sl *SkipList) PeekMin() (interface{}, interface{}, bool) {
+	x := sl.head.forward[0]
+	if x == nil {
+		return nil, nil, false
+	}
+	return x.key, x.value, true
+}
+
+// 删除并返回最小的键值对，跳表为空时返回false，可以把跳表当作优先队列使用
+// 第一个节点在它的每一层上的前驱都是head，不需要查找，复杂度为O(节点层数)
+func (sl *SkipList) PopMin() (interface{}, interface{}, bool) {
+	x := sl.head.forward[0]
+	if x == nil {
+		return nil, nil, false
+	}
+	key, value := x.key, x.value
+
+	for i := range x.forward {
+		sl.head.forward[i] = x.forward[i]
+	}
+	for sl.level > 1 && sl.head.forward[sl.level-1] == nil {
+		sl.level--
+	}
+
+	sl.size--
+	sl.modCount++
+	sl.tail = sl.tail[:0]
+	sl.freeNode(x)
+	return key, value, true
+}
+
+// 查找第一个键大于等于key的节点，不存在时返回nil
+func (sl *SkipList) findGreaterOrEqual(key interface{}) *Node {
+	return sl.findLessThan(key).forward[0]
+}
+
+// 查找最后一个键小于key的节点，不存在时返回head
+func (sl *SkipList) findLessThan(key interface{}) *Node {
+	x := sl.head
+
+	for i := sl.level - 1; i >= 0; i-- {
+		for x.forward[i] != nil && sl.comparator.Compare(x.forward[i].key, key) < 0 {
+			x = x.forward[i]
+		}
+	}
+
+	return x
+}
+
+// 查找最后一个节点，跳表为空时返回head
+func (sl *SkipList) findLast() *Node {
+	x := sl.head
+
+	for i := sl.level - 1; i >= 0; i-- {
+		for x.forward[i] != nil {
+			x = x.forward[i]
+		}
+	}
+
+	return x
+}
+
+// 统计键落在[start, end)范围内的节点数量，start或end为nil表示该侧无边界
+// 复杂度为O(log n + 范围内节点数)
+func (sl *SkipList) CountRange(start, end interface{}) int {
+	x := sl.head.forward[0]
+	if start != nil {
+		x = sl.findGreaterOrEqual(start)
+	}
+
+	count := 0
+	for x != nil && (end == nil || sl.comparator.Compare(x.key, end) < 0) {
+		count++
+		x = x.forward[0]
+	}
+	return count
+}
+
 // 迭代器相关功能，用于范围遍历
+// 迭代过程中跳表发生结构修改时，Next和Prev会panic(fail-fast)，避免静默地跳过或重复访问节点
+//
+// 迭代器支持双向移动: 越过最后一个节点后Prev回到最后一个节点，
+// 越过第一个节点后(停在head上)Next回到第一个节点
 type Iterator struct {
-	list    *SkipList
-	current *Node
+	list     *SkipList
+	current  *Node  // nil表示越过了最后一个节点，head表示越过了第一个节点
+	modCount uint64 // 迭代器定位时跳表的修改次数
 }
 
 func (sl *SkipList) NewIterator() *Iterator {
 	return &Iterator{
-		list:    sl,
-		current: sl.head.forward[0],
+		list:     sl,
+		current:  sl.head.forward[0],
+		modCount: sl.modCount,
 	}
 }
 
 func (iter *Iterator) Valid() bool {
-	return iter.current != nil
+	return iter.current != nil && iter.current != iter.list.head
 }
 
 func (iter *Iterator) Key() interface{} {
@@ -184,20 +426,38 @@ func (iter *Iterator) Value() interface{} {
 }
 
 func (iter *Iterator) Next() {
-	if !iter.Valid() {
+	if iter.current == nil {
 		panic("Invalid iterator")
 	}
+	if iter.modCount != iter.list.modCount {
+		panic("SkipList modified during iteration")
+	}
 	iter.current = iter.current.forward[0]
 }
 
-func (iter *Iterator) Seek(key interface{}) {
-	x := iter.list.head
+// 移动到前一个节点，没有前向指针，通过从head重新查找实现，复杂度O(log n)
+func (iter *Iterator) Prev() {
+	if iter.current == iter.list.head {
+		panic("Invalid iterator")
+	}
+	if iter.modCount != iter.list.modCount {
+		panic("SkipList modified during iteration")
+	}
 
-	for i := iter.list.level - 1; i >= 0; i-- {
-		for x.forward[i] != nil && iter.list.comparator.Compare(x.forward[i].key, key) < 0 {
-			x = x.forward[i]
-		}
+	if iter.current == nil {
+		iter.current = iter.list.findLast()
+	} else {
+		iter.current = iter.list.findLessThan(iter.current.key)
 	}
+}
+
+func (iter *Iterator) Seek(key interface{}) {
+	iter.current = iter.list.findGreaterOrEqual(key)
+	iter.modCount = iter.list.modCount
+}
 
-	iter.current = x.forward[0]
+// 回到第一个节点，跳表为空时迭代器无效，复杂度O(1)
+func (iter *Iterator) SeekToFirst() {
+	iter.current = iter.list.head.forward[0]
+	iter.modCount = iter.list.modCount
 }
@@ -0,0 +1,615 @@
+package skiplist
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+	"testing"
+)
+
+// 插入keys中的每个键，值为键本身，返回排序去重后的键
+func buildIntList(keys []int) (*SkipList, []int) {
+	sl := NewSkipList(IntComparator{})
+	seen := map[int]bool{}
+	var sorted []int
+	for _, k := range keys {
+		sl.Insert(k, k)
+		if !seen[k] {
+			seen[k] = true
+			sorted = append(sorted, k)
+		}
+	}
+	sort.Ints(sorted)
+	return sl, sorted
+}
+
+func randomKeys(n, max int) []int {
+	r := rand.New(rand.NewSource(1))
+	keys := make([]int, n)
+	for i := range keys {
+		keys[i] = r.Intn(max)
+	}
+	return keys
+}
+
+func TestCountRange(t *testing.T) {
+	sl, sorted := buildIntList(randomKeys(2000, 5000))
+
+	// 参考实现: 线性统计
+	reference := func(start, end interface{}) int {
+		count := 0
+		for _, k := range sorted {
+			if (start == nil || k >= start.(int)) && (end == nil || k < end.(int)) {
+				count++
+			}
+		}
+		return count
+	}
+
+	cases := [][2]interface{}{
+		{nil, nil},   // 全部
+		{0, 5000},    // 全部
+		{-10, 0},     // 空
+		{5000, 6000}, // 空
+		{100, 100},   // 空区间
+		{300, 200},   // 反向区间
+		{nil, 1000},
+		{4000, nil},
+		{sorted[10], sorted[20]}, // 边界恰好是已有的键
+		{sorted[10] + 1, sorted[20] + 1},
+	}
+	for _, c := range cases {
+		if got, want := sl.CountRange(c[0], c[1]), reference(c[0], c[1]); got != want {
+			t.Errorf("CountRange(%v, %v) = %d, want %d", c[0], c[1], got, want)
+		}
+	}
+
+	empty := NewSkipList(IntComparator{})
+	if got := empty.CountRange(nil, nil); got != 0 {
+		t.Errorf("empty list: CountRange = %d, want 0", got)
+	}
+}
+
+// 调用fn并检查它以want为信息panic
+func expectPanic(t *testing.T, want string, fn func()) {
+	t.Helper()
+
+	defer func() {
+		t.Helper()
+		if r := recover(); r != want {
+			t.Fatalf("got panic %v, want %q", r, want)
+		}
+	}()
+	fn()
+}
+
+func TestIteratorFailFast(t *testing.T) {
+	const modified = "SkipList modified during iteration"
+
+	mutations := map[string]func(sl *SkipList){
+		"Insert":       func(sl *SkipList) { sl.Insert(1000, 0) },
+		"InsertUnique": func(sl *SkipList) { sl.InsertUnique(1001, 0) },
+		"Delete":       func(sl *SkipList) { sl.Delete(5) },
+		"PopMin":       func(sl *SkipList) { sl.PopMin() },
+	}
+	for name, mutate := range mutations {
+		sl, _ := buildIntList([]int{1, 2, 3, 4, 5, 6})
+		iter := sl.NewIterator()
+		iter.Next()
+		mutate(sl)
+		t.Run(name, func(t *testing.T) {
+			expectPanic(t, modified, iter.Next)
+		})
+	}
+
+	// 覆盖已有键的值不改变结构，迭代可以继续
+	sl, _ := buildIntList([]int{1, 2, 3})
+	iter := sl.NewIterator()
+	sl.Insert(2, "new")
+	iter.Next()
+	if iter.Key() != 2 || iter.Value() != "new" {
+		t.Fatalf("got %v=%v after overwrite, want 2=new", iter.Key(), iter.Value())
+	}
+
+	// 重新定位后迭代器与跳表同步
+	sl.Insert(10, 10)
+	iter.Seek(3)
+	iter.Next()
+	if iter.Key() != 10 {
+		t.Fatalf("got %v after Seek, want 10", iter.Key())
+	}
+}
+
+func TestInsertUnique(t *testing.T) {
+	sl := NewSkipList(IntComparator{})
+
+	if !sl.InsertUnique(1, "first") {
+		t.Fatal("InsertUnique of a new key returned false")
+	}
+	if sl.InsertUnique(1, "second") {
+		t.Fatal("InsertUnique of an existing key returned true")
+	}
+	if value, _ := sl.Find(1); value != "first" || sl.Size() != 1 {
+		t.Fatalf("got %v with size %d, want first with size 1", value, sl.Size())
+	}
+
+	// 覆盖写入不受影响
+	sl.Insert(1, "third")
+	if value, _ := sl.Find(1); value != "third" {
+		t.Fatalf("got %v after Insert, want third", value)
+	}
+}
+
+// 按跳表的顺序收集所有键值对
+func collect(sl *SkipList) ([]interface{}, []interface{}) {
+	var keys, values []interface{}
+	for iter := sl.NewIterator(); iter.Valid(); iter.Next() {
+		keys = append(keys, iter.Key())
+		values = append(values, iter.Value())
+	}
+	return keys, values
+}
+
+func TestNodePool(t *testing.T) {
+	sl := NewSkipList(IntComparator{})
+	sl.SetNodePoolSize(16)
+
+	// 与map对照随机插入和删除，复用的节点不能带有旧的键值或指针
+	reference := map[int]int{}
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 20000; i++ {
+		k := r.Intn(200)
+		if r.Intn(2) == 0 {
+			sl.Insert(k, i)
+			reference[k] = i
+		} else {
+			_, existed := reference[k]
+			if sl.Delete(k) != existed {
+				t.Fatalf("Delete(%d) returned %v, want %v", k, !existed, existed)
+			}
+			delete(reference, k)
+		}
+		if sl.freeCount > 16 {
+			t.Fatalf("free list holds %d nodes, limit 16", sl.freeCount)
+		}
+	}
+
+	keys, values := collect(sl)
+	if len(keys) != len(reference) || sl.Size() != len(reference) {
+		t.Fatalf("got %d keys (size %d), want %d", len(keys), sl.Size(), len(reference))
+	}
+	for i, k := range keys {
+		if i > 0 && keys[i-1].(int) >= k.(int) {
+			t.Fatalf("keys out of order: %v before %v", keys[i-1], k)
+		}
+		if values[i] != reference[k.(int)] {
+			t.Fatalf("key %v: got %v, want %v", k, values[i], reference[k.(int)])
+		}
+	}
+
+	// 缩小复用池时释放多余的节点
+	sl.SetNodePoolSize(2)
+	if sl.freeCount > 2 {
+		t.Fatalf("free list holds %d nodes after shrinking to 2", sl.freeCount)
+	}
+	sl.SetNodePoolSize(0)
+	for i := 0; i < 100; i++ {
+		sl.Delete(i)
+	}
+	if sl.freeCount != 0 {
+		t.Fatalf("free list holds %d nodes with pooling disabled", sl.freeCount)
+	}
+
+	// 负数等同于0，不能卡住
+	sl.SetNodePoolSize(8)
+	for i := 0; i < 8; i++ {
+		sl.Insert(i, i)
+	}
+	for i := 0; i < 8; i++ {
+		sl.Delete(i)
+	}
+	sl.SetNodePoolSize(-1)
+	if sl.poolSize != 0 || sl.freeCount != 0 {
+		t.Fatalf("negative size: poolSize %d, freeCount %d", sl.poolSize, sl.freeCount)
+	}
+	sl.Insert(1, 1)
+	sl.Delete(1)
+	if sl.freeCount != 0 {
+		t.Fatalf("free list holds %d nodes after negative size", sl.freeCount)
+	}
+}
+
+func BenchmarkDeleteInsert(b *testing.B) {
+	for _, poolSize := range []int{0, 1024} {
+		b.Run(fmt.Sprintf("pool=%d", poolSize), func(b *testing.B) {
+			sl := NewSkipList(IntComparator{})
+			sl.SetNodePoolSize(poolSize)
+			for i := 0; i < 1024; i++ {
+				sl.Insert(i, i)
+			}
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				k := i % 1024
+				sl.Delete(k)
+				sl.Insert(k, i)
+			}
+		})
+	}
+}
+
+func TestIteratorDirectionChange(t *testing.T) {
+	sl, sorted := buildIntList(randomKeys(500, 2000))
+
+	// 在每个定位点附近交替Next和Prev，与排序后的参考结果对照
+	r := rand.New(rand.NewSource(2))
+	for n := 0; n < 200; n++ {
+		target := r.Intn(2100) - 50
+		pos := sort.SearchInts(sorted, target)
+
+		iter := sl.NewIterator()
+		iter.Seek(target)
+		for step := 0; step < 20; step++ {
+			valid := pos >= 0 && pos < len(sorted)
+			if iter.Valid() != valid {
+				t.Fatalf("seek %d step %d: Valid() = %v, want %v", target, step, iter.Valid(), valid)
+			}
+			if valid && iter.Key() != sorted[pos] {
+				t.Fatalf("seek %d step %d: got %v, want %d", target, step, iter.Key(), sorted[pos])
+			}
+
+			// 越过两端后只能向回移动
+			forward := r.Intn(2) == 0
+			if pos < 0 {
+				forward = true
+			} else if pos >= len(sorted) {
+				forward = false
+			}
+			if forward {
+				iter.Next()
+				pos++
+			} else {
+				iter.Prev()
+				pos--
+			}
+		}
+	}
+
+	// Prev同样检测迭代期间的结构修改
+	iter := sl.NewIterator()
+	iter.Seek(sorted[10])
+	sl.Delete(sorted[5])
+	expectPanic(t, "SkipList modified during iteration", iter.Prev)
+
+	iter.Seek(sorted[10])
+	iter.Prev()
+	if iter.Key() != sorted[9] {
+		t.Fatalf("got %v after re-seek and Prev, want %d", iter.Key(), sorted[9])
+	}
+}
+
+func TestRandomLevelDistribution(t *testing.T) {
+	sl := NewSkipList(IntComparator{})
+	sl.rng = 88172645463325252
+
+	// 每升高一层的概率为1/4，第k层(从1开始)的期望比例为(3/4)*(1/4)^(k-1)
+	const n = 1 << 20
+	counts := make([]int, maxLevel+1)
+	for i := 0; i < n; i++ {
+		level := sl.randomLevel()
+		if level < 1 || level > maxLevel {
+			t.Fatalf("level %d out of range", level)
+		}
+		counts[level]++
+	}
+
+	p := 0.75
+	for level := 1; level <= 6; level++ {
+		expected := n * p
+		// 允许5个标准差的偏差
+		tolerance := 5 * math.Sqrt(expected*(1-p))
+		if diff := math.Abs(float64(counts[level]) - expected); diff > tolerance {
+			t.Errorf("level %d: got %d, want %.0f ± %.0f", level, counts[level], expected, tolerance)
+		}
+		p /= 4
+	}
+}
+
+func BenchmarkInsertRandom(b *testing.B) {
+	keys := randomKeys(b.N, 1<<30)
+	sl := NewSkipList(IntComparator{})
+
+	b.ResetTimer()
+	for _, k := range keys {
+		sl.Insert(k, nil)
+	}
+}
+
+// 与逐层抛硬币的实现对比
+func BenchmarkRandomLevel(b *testing.B) {
+	b.Run("bits", func(b *testing.B) {
+		sl := NewSkipList(IntComparator{})
+		for i := 0; i < b.N; i++ {
+			sl.randomLevel()
+		}
+	})
+	b.Run("float", func(b *testing.B) {
+		r := rand.New(rand.NewSource(1))
+		for i := 0; i < b.N; i++ {
+			level := 1
+			for r.Float64() < 0.25 && level < maxLevel {
+				level++
+			}
+		}
+	})
+}
+
+func TestFirstAndLastKey(t *testing.T) {
+	sl := NewSkipList(IntComparator{})
+	if _, ok := sl.FirstKey(); ok {
+		t.Fatal("FirstKey on empty list returned true")
+	}
+	if _, ok := sl.LastKey(); ok {
+		t.Fatal("LastKey on empty list returned true")
+	}
+
+	sl, sorted := buildIntList(randomKeys(1000, 100000))
+	check := func() {
+		t.Helper()
+		first, ok := sl.FirstKey()
+		if !ok || first != sorted[0] {
+			t.Fatalf("FirstKey = %v, %v, want %d", first, ok, sorted[0])
+		}
+		last, ok := sl.LastKey()
+		if !ok || last != sorted[len(sorted)-1] {
+			t.Fatalf("LastKey = %v, %v, want %d", last, ok, sorted[len(sorted)-1])
+		}
+	}
+	check()
+
+	// 删除两端的键后仍然正确
+	sl.Delete(sorted[0])
+	sl.Delete(sorted[len(sorted)-1])
+	sorted = sorted[1 : len(sorted)-1]
+	check()
+
+	// 删除所有键后回到空表
+	for _, k := range sorted {
+		sl.Delete(k)
+	}
+	if _, ok := sl.LastKey(); ok {
+		t.Fatal("LastKey after deleting every key returned true")
+	}
+}
+
+func TestLazyHeadGrowth(t *testing.T) {
+	sl := NewSkipList(IntComparator{})
+	if len(sl.head.forward) != 1 {
+		t.Fatalf("new list head has %d pointers, want 1", len(sl.head.forward))
+	}
+
+	// 少量节点时head只按实际层数增长
+	for i := 0; i < 8; i++ {
+		sl.Insert(i, i)
+	}
+	if len(sl.head.forward) != sl.level || sl.level > maxLevel/2 {
+		t.Fatalf("head has %d pointers at level %d", len(sl.head.forward), sl.level)
+	}
+
+	// 增长后仍然正确
+	sl, sorted := buildIntList(randomKeys(5000, 100000))
+	if len(sl.head.forward) < sl.level {
+		t.Fatalf("head has %d pointers, below level %d", len(sl.head.forward), sl.level)
+	}
+	keys, _ := collect(sl)
+	if len(keys) != len(sorted) {
+		t.Fatalf("got %d keys, want %d", len(keys), len(sorted))
+	}
+	for i := range keys {
+		if keys[i] != sorted[i] {
+			t.Fatalf("key %d: got %v, want %d", i, keys[i], sorted[i])
+		}
+		if _, found := sl.Find(sorted[i]); !found {
+			t.Fatalf("Find(%d) failed", sorted[i])
+		}
+	}
+}
+
+func TestSeekToFirst(t *testing.T) {
+	sl, sorted := buildIntList(randomKeys(200, 1000))
+
+	iter := sl.NewIterator()
+	iter.Seek(sorted[100])
+	iter.Next()
+	iter.SeekToFirst()
+	if !iter.Valid() || iter.Key() != sorted[0] {
+		t.Fatalf("got %v after SeekToFirst, want %d", iter.Key(), sorted[0])
+	}
+
+	// 越过末尾后回到开头，并且与跳表的修改同步
+	iter.Seek(sorted[len(sorted)-1] + 1)
+	if iter.Valid() {
+		t.Fatal("iterator valid past the last key")
+	}
+	sl.Insert(-1, nil)
+	iter.SeekToFirst()
+	if iter.Key() != -1 {
+		t.Fatalf("got %v after insert and SeekToFirst, want -1", iter.Key())
+	}
+	iter.Next()
+	if iter.Key() != sorted[0] {
+		t.Fatalf("got %v, want %d", iter.Key(), sorted[0])
+	}
+
+	empty := NewSkipList(IntComparator{}).NewIterator()
+	empty.SeekToFirst()
+	if empty.Valid() {
+		t.Fatal("iterator over an empty list is valid")
+	}
+}
+
+// 检查每一层都按键递增且只包含层数足够的节点，追加缓存有效时指向每层的最后一个节点
+func checkStructure(t *testing.T, sl *SkipList) {
+	t.Helper()
+
+	for i := 0; i < sl.level; i++ {
+		last := sl.head
+		for x := sl.head.forward[i]; x != nil; x = x.forward[i] {
+			if len(x.forward) <= i {
+				t.Fatalf("node %v with %d levels linked at level %d", x.key, len(x.forward), i)
+			}
+			if last != sl.head && sl.comparator.Compare(last.key, x.key) >= 0 {
+				t.Fatalf("level %d: %v before %v", i, last.key, x.key)
+			}
+			last = x
+		}
+		if len(sl.tail) > 0 && sl.tail[i] != last {
+			t.Fatalf("tail cache at level %d points to %v, last node is %v", i, sl.tail[i].key, last.key)
+		}
+	}
+}
+
+func TestSequentialInsertFastPath(t *testing.T) {
+	sl := NewSkipList(IntComparator{})
+	reference := map[int]bool{}
+	insert := func(keys ...int) {
+		for _, k := range keys {
+			sl.Insert(k, k)
+			reference[k] = true
+		}
+		checkStructure(t, sl)
+	}
+
+	// 递增插入，中途插入较小的键和已有的键，之后继续递增
+	for i := 0; i < 1000; i += 2 {
+		insert(i)
+	}
+	insert(501, -5, 998, 250)
+	for i := 1000; i < 2000; i++ {
+		insert(i)
+	}
+
+	// 删除和PopMin使缓存失效，之后仍然正确
+	sl.Delete(1999)
+	delete(reference, 1999)
+	sl.PopMin()
+	delete(reference, -5)
+	checkStructure(t, sl)
+	insert(1999, 2000, 3, 2001)
+
+	keys, _ := collect(sl)
+	if len(keys) != len(reference) || sl.Size() != len(reference) {
+		t.Fatalf("got %d keys (size %d), want %d", len(keys), sl.Size(), len(reference))
+	}
+	for i := range keys {
+		if !reference[keys[i].(int)] || (i > 0 && keys[i-1].(int) >= keys[i].(int)) {
+			t.Fatalf("unexpected key order around %v", keys[i])
+		}
+	}
+}
+
+func BenchmarkInsertSequential(b *testing.B) {
+	sl := NewSkipList(IntComparator{})
+	for i := 0; i < b.N; i++ {
+		sl.Insert(i, nil)
+	}
+}
+
+func TestPriorityQueue(t *testing.T) {
+	sl := NewSkipList(IntComparator{})
+	if _, _, ok := sl.PeekMin(); ok {
+		t.Fatal("PeekMin on empty list returned true")
+	}
+	if _, _, ok := sl.PopMin(); ok {
+		t.Fatal("PopMin on empty list returned true")
+	}
+
+	sl, sorted := buildIntList(randomKeys(2000, 1000000))
+	sl.SetNodePoolSize(8)
+
+	// 重复PopMin按升序返回所有键值对，最后跳表为空
+	for i, want := range sorted {
+		key, value, ok := sl.PeekMin()
+		if !ok || key != want || value != want {
+			t.Fatalf("PeekMin #%d = %v=%v, %v, want %d", i, key, value, ok, want)
+		}
+		key, value, ok = sl.PopMin()
+		if !ok || key != want || value != want {
+			t.Fatalf("PopMin #%d = %v=%v, %v, want %d", i, key, value, ok, want)
+		}
+		if sl.Size() != len(sorted)-i-1 {
+			t.Fatalf("size %d after %d pops", sl.Size(), i+1)
+		}
+		if i%100 == 0 {
+			checkStructure(t, sl)
+		}
+	}
+	if _, _, ok := sl.PopMin(); ok || sl.level != 1 {
+		t.Fatalf("list not empty after popping every key (level %d)", sl.level)
+	}
+
+	// 清空后仍然可以正常使用
+	sl.Insert(3, 3)
+	sl.Insert(1, 1)
+	if key, _, _ := sl.PopMin(); key != 1 {
+		t.Fatalf("got %v, want 1", key)
+	}
+}
+
+func TestDeleteAndReturn(t *testing.T) {
+	sl := NewSkipList(StringComparator{})
+	sl.Insert("a", 1)
+	sl.Insert("b", "two")
+	sl.Insert("c", nil)
+
+	value, existed := sl.DeleteAndReturn("b")
+	if !existed || value != "two" {
+		t.Fatalf("got %v, %v, want two, true", value, existed)
+	}
+	if _, found := sl.Find("b"); found || sl.Size() != 2 {
+		t.Fatalf("b still present, size %d", sl.Size())
+	}
+
+	// 键不存在时返回nil和false，键存在但值为nil时返回nil和true
+	if value, existed := sl.DeleteAndReturn("b"); existed || value != nil {
+		t.Fatalf("deleting b again: got %v, %v", value, existed)
+	}
+	if value, existed := sl.DeleteAndReturn("c"); !existed || value != nil {
+		t.Fatalf("deleting c: got %v, %v, want nil, true", value, existed)
+	}
+
+	// 节点被复用后返回的值不受影响
+	sl.SetNodePoolSize(4)
+	value, _ = sl.DeleteAndReturn("a")
+	sl.Insert("d", 4)
+	if value != 1 {
+		t.Fatalf("got %v after node reuse, want 1", value)
+	}
+}
+
+func TestInsertAndReturn(t *testing.T) {
+	sl := NewSkipList(StringComparator{})
+
+	// 新键返回nil和false
+	if old, existed := sl.InsertAndReturn("a", 1); existed || old != nil {
+		t.Fatalf("inserting a: got %v, %v", old, existed)
+	}
+	// 已存在的键返回被覆盖的值
+	old, existed := sl.InsertAndReturn("a", 2)
+	if !existed || old != 1 {
+		t.Fatalf("got %v, %v, want 1, true", old, existed)
+	}
+	if value, _ := sl.Find("a"); value != 2 || sl.Size() != 1 {
+		t.Fatalf("got %v, size %d, want 2, 1", value, sl.Size())
+	}
+
+	// InsertUnique不覆盖已存在的值
+	if sl.InsertUnique("a", 3) {
+		t.Fatal("InsertUnique overwrote a")
+	}
+	if value, _ := sl.Find("a"); value != 2 {
+		t.Fatalf("got %v after InsertUnique, want 2", value)
+	}
+}
@@ -0,0 +1,17 @@
+//go:build linux
+
+package wal
+
+import (
+	"os"
+	"syscall"
+)
+
+// 为文件[offset, offset+length)分配磁盘空间，文件大小随之扩展
+func preallocate(file *os.File, offset, length int64) error {
+	err := syscall.Fallocate(int(file.Fd()), 0, offset, length)
+	if err == syscall.EOPNOTSUPP || err == syscall.ENOSYS {
+		return file.Truncate(offset + length)
+	}
+	return err
+}
@@ -0,0 +1,10 @@
+//go:build !linux
+
+package wal
+
+import "os"
+
+// 不支持fallocate的平台直接扩展文件大小
+func preallocate(file *os.File, offset, length int64) error {
+	return file.Truncate(offset + length)
+}
@@ -0,0 +1,116 @@
+package wal
+
+import (
+	"encoding/binary"
+	"os"
+)
+
+// 预分配的空间和块末尾的填充都是0，片段头全为0说明该块之后没有有效数据
+func isZeroHeader(header []byte) bool {
+	for _, b := range header {
+		if b != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// 确保文件至少分配了size大小的空间，调用方需持有w.mu
+func (w *WAL) reserve(size int64) error {
+	if w.preallocateSize <= 0 || size <= w.allocated {
+		return nil
+	}
+
+	allocated := w.allocated + w.preallocateSize
+	if allocated < size {
+		allocated = size
+	}
+	if err := preallocate(w.file, w.allocated, allocated-w.allocated); err != nil {
+		return err
+	}
+	w.allocated = allocated
+	return nil
+}
+
+// 扫描日志文件，找到有效数据的末尾，即最后一条完整记录的末尾
+// 预分配的文件末尾是未使用的0，崩溃时文件末尾可能留下只写入了一部分的记录，
+// 之后的写入从有效数据的末尾开始，覆盖这些数据
+// 每个块中遇到全为0或无效的片段头、不完整的片段时停止扫描该块，从下一个块继续；
+// 数据校验失败的片段被跳过。因此损坏的数据之后的完整记录仍然计入，损坏由读取时报告
+// 没有完整的记录且文件开头的片段头无效(类型、长度或校验算法无法识别)时返回*CorruptionError，
+// 避免把其他格式的日志当作空日志覆盖。片段头完好只是数据校验失败时按损坏处理，不返回错误
+func findLogicalEnd(file *os.File, fileSize int64) (int64, error) {
+	block := make([]byte, blockSize)
+	end := int64(0)
+	inRecord := false      // 是否读到了记录的FIRST片段，尚未读到LAST片段
+	var unrecognized error // 文件开头的片段头无效的原因
+
+	for blockStart := int64(0); blockStart < fileSize; blockStart += blockSize {
+		n := int64(blockSize)
+		if fileSize-blockStart < n {
+			n = fileSize - blockStart
+		}
+		if _, err := file.ReadAt(block[:n], blockStart); err != nil {
+			return 0, err
+		}
+
+		pos := 0
+		for pos+headerSize <= int(n) {
+			start := pos
+			header := block[pos : pos+headerSize]
+			if isZeroHeader(header) {
+				break
+			}
+
+			fragmentType := header[6] & fragmentTypeMask
+			if fragmentType < fragmentFull || fragmentType > fragmentLast {
+				if blockStart == 0 && start == 0 {
+					unrecognized = ErrInvalidRecord
+				}
+				break
+			}
+			length := int(binary.LittleEndian.Uint16(header[4:6]))
+			if blockStart == 0 && start == 0 && (length > blockSize-headerSize || header[6]>>4 > ChecksumCRC32C) {
+				unrecognized = ErrInvalidRecord
+				break
+			}
+			if pos+headerSize+length > int(n) {
+				// 只写入了一部分的片段
+				break
+			}
+			data := block[pos+headerSize : pos+headerSize+length]
+			checksum, ok := fragmentChecksum(header[6]>>4, header[6], data)
+			pos += headerSize + length
+			if !ok || checksum != binary.LittleEndian.Uint32(header[0:4]) {
+				// 片段头完好时跳过损坏的数据继续扫描，之后的完整记录仍然有效
+				inRecord = false
+				continue
+			}
+
+			// 只有完整的记录才计入有效数据，没有开头的MIDDLE和LAST片段被忽略
+			switch fragmentType {
+			case fragmentFull:
+				inRecord = false
+				end = blockStart + int64(pos)
+			case fragmentFirst:
+				inRecord = true
+			case fragmentLast:
+				if inRecord {
+					end = blockStart + int64(pos)
+				}
+				inRecord = false
+			}
+		}
+
+		// 块在末尾之前停止扫描时，跨越该块的记录不完整
+		if pos+headerSize <= int(n) {
+			inRecord = false
+		}
+	}
+
+	// 没有任何完整的记录时，开头的片段头无效说明不是本格式的日志(例如旧格式)，而不是崩溃留下的不完整记录
+	if end == 0 && unrecognized != nil {
+		return 0, &CorruptionError{File: file.Name(), Offset: 0, Err: unrecognized}
+	}
+	return end, nil
+}
@@ -0,0 +1,58 @@
+package wal
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+	"testing"
+)
+
+// 恢复时读取整个日志的速度，用于评估读取路径的改动
+func BenchmarkRecovery(b *testing.B) {
+	path := filepath.Join(b.TempDir(), "wal")
+	w, err := OpenWithOptions(path, Options{WriteBufferSize: 1 << 20})
+	if err != nil {
+		b.Fatal(err)
+	}
+	const n = 100000
+	records := make([]Record, n)
+	for i := range records {
+		records[i] = Record{
+			Type:  TypePut,
+			Key:   []byte(fmt.Sprintf("sensor/temperature/2024-01-01T00:00:%08d", i)),
+			Value: []byte(fmt.Sprintf("value-%d", i)),
+		}
+	}
+	if err := w.WriteBatch(records); err != nil {
+		b.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		b.Fatal(err)
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		w, err := Open(path, false)
+		if err != nil {
+			b.Fatal(err)
+		}
+		it, err := w.NewIterator()
+		if err != nil {
+			b.Fatal(err)
+		}
+		count := 0
+		for {
+			if _, err := it.Next(); err == io.EOF {
+				break
+			} else if err != nil {
+				b.Fatal(err)
+			}
+			count++
+		}
+		it.Close()
+		w.Close()
+		if count != n {
+			b.Fatalf("recovered %d records, want %d", count, n)
+		}
+	}
+}
@@ -0,0 +1,36 @@
+package wal
+
+import (
+	"errors"
+	"io"
+)
+
+// 读取整个日志，对每条完好的记录调用fn(record, offset, nil)，
+// 对每处损坏调用fn(nil, offset, err)，err为*CorruptionError，offset为损坏的位置
+// 遇到损坏时不会停止，而是跳过损坏的片段(片段头无效时跳到下一个块)，继续报告之后的记录，用于检查和分析日志
+// 按顺序回调，读取文件失败时停止并返回错误
+func (w *WAL) Scan(fn func(record *Record, offset int64, err error)) error {
+	it, err := w.NewIterator()
+	if err != nil {
+		return err
+	}
+	defer it.Close()
+
+	for {
+		record, err := it.Next()
+		if err == io.EOF {
+			return nil
+		}
+
+		var corruption *CorruptionError
+		if errors.As(err, &corruption) {
+			fn(nil, corruption.Offset, corruption)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		fn(record, it.lastPos, nil)
+	}
+}
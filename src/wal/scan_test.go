@@ -0,0 +1,88 @@
+package wal
+
+import (
+	"bytes"
+	"errors"
+	"path/filepath"
+	"sort"
+	"testing"
+)
+
+func TestScan(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "wal")
+	w, err := Open(path, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	records := sequentialRecords(8000)
+	// 加入跨越多个块的大记录
+	records[4000].Value = bytes.Repeat([]byte("v"), 2*blockSize)
+	offsets := writeRecords(t, w, records)
+	w.Close()
+
+	// 破坏第2个和第4个块中间的记录，以及大记录的第一个片段
+	var bad []int
+	for _, block := range []int64{1, 3} {
+		i := sort.Search(len(offsets), func(i int) bool { return offsets[i] >= block*blockSize+blockSize/2 })
+		bad = append(bad, i)
+	}
+	bad = append(bad, 4000)
+	sort.Ints(bad)
+	for _, i := range bad {
+		flipByte(t, path, offsets[i]+headerSize+1)
+	}
+
+	w, err = Open(path, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	reported := map[int64]*Record{}
+	var corruptions []int64
+	err = w.Scan(func(record *Record, offset int64, err error) {
+		if err != nil {
+			var corruption *CorruptionError
+			if record != nil || !errors.As(err, &corruption) || corruption.Offset != offset {
+				t.Errorf("offset %d: got record %v, error %v", offset, record, err)
+			}
+			corruptions = append(corruptions, offset)
+			return
+		}
+		reported[offset] = record
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 每处损坏按顺序报告一次，位置正确
+	if len(corruptions) != len(bad) {
+		t.Fatalf("got corruptions at %v, want %d", corruptions, len(bad))
+	}
+	for j, i := range bad {
+		if corruptions[j] != offsets[i] {
+			t.Fatalf("got corruptions at %v, want record %d at %d", corruptions, i, offsets[i])
+		}
+	}
+
+	// 除了损坏的记录，所有记录都以正确的位置报告，包括损坏块中之后的记录
+	isBad := map[int]bool{}
+	for _, i := range bad {
+		isBad[i] = true
+	}
+	for i, record := range records {
+		got, ok := reported[offsets[i]]
+		if isBad[i] {
+			if ok {
+				t.Fatalf("corrupted record %d was reported", i)
+			}
+			continue
+		}
+		if !ok || !sameRecords([]Record{*got}, []Record{record}) {
+			t.Fatalf("record %d at offset %d: got %v", i, offsets[i], got)
+		}
+	}
+	if len(reported) != len(records)-len(bad) {
+		t.Fatalf("got %d records, want %d", len(reported), len(records)-len(bad))
+	}
+}
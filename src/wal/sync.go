@@ -0,0 +1,89 @@
+package wal
+
+import "time"
+
+// 返回日志的逻辑末尾，包括缓冲区中尚未写入文件的记录
+// 写入一条记录后立即调用End，再将结果传给SyncTo，即可等待该记录同步到磁盘
+func (w *WAL) End() int64 {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.end()
+}
+
+// 返回已经确认同步到磁盘的日志大小，不超过End
+func (w *WAL) Synced() int64 {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.synced
+}
+
+// 等待日志中offset之前的数据同步到磁盘
+// 并发的调用合并为一次fsync: 第一个调用方写入缓冲区并在锁外执行fsync，
+// 其余调用方等待它完成，期间其他记录可以继续写入
+// WAL被截断后offset超出日志末尾的部分视为已同步
+func (w *WAL) SyncTo(offset int64) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	for {
+		if w.closed {
+			return &ClosedError{File: w.file.Name()}
+		}
+		if end := w.end(); offset > end {
+			offset = end
+		}
+		if w.synced >= offset {
+			return nil
+		}
+		if w.syncing {
+			w.syncCond.Wait()
+			continue
+		}
+
+		if err := w.flush(); err != nil {
+			return err
+		}
+		target, epoch := w.size, w.epoch
+
+		w.syncing = true
+		w.mu.Unlock()
+		err := w.file.Sync()
+		w.mu.Lock()
+		w.syncing = false
+		w.syncCond.Broadcast()
+
+		if err != nil {
+			return err
+		}
+		// fsync期间被截断时，target属于截断之前的日志
+		if w.epoch == epoch && target > w.synced {
+			w.synced = target
+		}
+	}
+}
+
+// 后台定期同步尚未同步的记录，直到WAL关闭
+// 同步失败时在下一个周期重试，错误由之后调用SyncTo的调用方得到
+func (w *WAL) syncLoop(interval time.Duration) {
+	defer w.syncer.Done()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-w.done:
+			return
+		}
+
+		w.mu.Lock()
+		end := w.end()
+		pending := !w.closed && w.synced < end
+		w.mu.Unlock()
+		if pending {
+			w.SyncTo(end)
+		}
+	}
+}
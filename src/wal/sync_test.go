@@ -0,0 +1,150 @@
+package wal
+
+import (
+	"errors"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSyncTo(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "wal")
+	w, err := OpenWithOptions(path, Options{WriteBufferSize: 1 << 20})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	records := sequentialRecords(100)
+	writeRecords(t, w, records[:50])
+	end := w.End()
+
+	// 未同步时记录只在缓冲区中
+	if w.Synced() != 0 || fileSize(t, path) != 0 {
+		t.Fatalf("synced %d, file size %d before SyncTo", w.Synced(), fileSize(t, path))
+	}
+
+	// SyncTo写入缓冲区并同步
+	if err := w.SyncTo(end); err != nil {
+		t.Fatal(err)
+	}
+	if w.Synced() < end || fileSize(t, path) < end {
+		t.Fatalf("synced %d, file size %d after SyncTo(%d)", w.Synced(), fileSize(t, path), end)
+	}
+
+	// 已经同步的位置不需要再次同步，之后的写入不影响
+	writeRecords(t, w, records[50:])
+	if err := w.SyncTo(end); err != nil {
+		t.Fatal(err)
+	}
+	if w.Synced() != end {
+		t.Fatalf("synced %d, want %d", w.Synced(), end)
+	}
+
+	// 截断后超出日志末尾的位置视为已同步
+	if err := w.Truncate(); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.SyncTo(end); err != nil {
+		t.Fatalf("SyncTo after Truncate: %v", err)
+	}
+}
+
+func TestSyncToConcurrent(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "wal")
+	w, err := OpenWithOptions(path, Options{WriteBufferSize: 4096})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 并发的写入各自等待自己的记录同步，返回时记录一定已经同步
+	records := sequentialRecords(800)
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := g; i < len(records); i += 8 {
+				if err := w.Write(records[i]); err != nil {
+					t.Error(err)
+					return
+				}
+				end := w.End()
+				if err := w.SyncTo(end); err != nil {
+					t.Error(err)
+					return
+				}
+				if synced := w.Synced(); synced < end {
+					t.Errorf("SyncTo(%d) returned with synced %d", end, synced)
+					return
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+
+	if got := readAll(t, w); len(got) != len(records) {
+		t.Fatalf("got %d records, want %d", len(got), len(records))
+	}
+
+	// 关闭后返回ClosedError
+	w.Close()
+	var closed *ClosedError
+	if err := w.SyncTo(0); !errors.As(err, &closed) {
+		t.Fatalf("got %v, want *ClosedError", err)
+	}
+}
+
+func TestSyncInterval(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "wal")
+	w, err := OpenWithOptions(path, Options{WriteBufferSize: 1 << 20, SyncInterval: 10 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 不同步的写入由后台同步，不需要调用SyncTo
+	writeRecords(t, w, sequentialRecords(100))
+	end := w.End()
+	deadline := time.Now().Add(5 * time.Second)
+	for w.Synced() < end {
+		if time.Now().After(deadline) {
+			t.Fatalf("synced %d, want %d", w.Synced(), end)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if fileSize(t, path) < end {
+		t.Fatalf("file size %d after background sync, want %d", fileSize(t, path), end)
+	}
+
+	// Close等待后台同步退出
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan struct{})
+	go func() {
+		w.syncer.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("background sync still running after Close")
+	}
+}
+
+func TestCloseSyncs(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "wal")
+	w, err := OpenWithOptions(path, Options{WriteBufferSize: 1 << 20})
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeRecords(t, w, sequentialRecords(100))
+	end := w.End()
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if w.Synced() != end {
+		t.Fatalf("synced %d after Close, want %d", w.Synced(), end)
+	}
+}
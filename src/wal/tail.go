@@ -0,0 +1,103 @@
+package wal
+
+import (
+	"context"
+	"io"
+	"os"
+)
+
+// 唤醒等待新记录的Tail，调用方需持有w.mu
+func (w *WAL) notifyAppended() {
+	if w.appended != nil {
+		close(w.appended)
+		w.appended = nil
+	}
+}
+
+// 从fromOffset开始持续读取WAL记录，用于向从节点传输日志
+// 先发送已有的记录，之后阻塞等待并按顺序发送新写入的记录，ctx取消或WAL关闭后关闭channel
+// 不再读取channel时必须取消ctx，否则后台的读取会一直阻塞
+// fromOffset必须是一条记录的起始位置(例如0)或日志末尾，否则返回ErrInvalidOffset
+// WAL被截断后从头开始读取；遇到损坏的记录时关闭channel
+// 开启写缓冲时，记录写入文件后才会被发送
+func (w *WAL) Tail(ctx context.Context, fromOffset int64) (<-chan Record, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.closed {
+		return nil, &ClosedError{File: w.file.Name()}
+	}
+
+	f, err := os.Open(w.file.Name())
+	if err != nil {
+		return nil, err
+	}
+
+	// 校验fromOffset是记录的起始位置，同时还原前缀压缩所需的上一条记录的键
+	it := newIterator(f, 0, w.size)
+	if err := it.SeekTo(fromOffset); err != nil {
+		it.Close()
+		return nil, err
+	}
+
+	ch := make(chan Record)
+	go w.tail(ctx, it, w.epoch, ch)
+	return ch, nil
+}
+
+// Tail的后台读取循环
+func (w *WAL) tail(ctx context.Context, it *Iterator, epoch uint64, ch chan<- Record) {
+	defer close(ch)
+	defer it.Close()
+
+	for {
+		// 在锁内同时获取当前大小和唤醒channel，之后的写入一定会唤醒本轮等待
+		w.mu.Lock()
+		if w.appended == nil {
+			w.appended = make(chan struct{})
+		}
+		size, appended, truncated := w.size, w.appended, w.epoch != epoch
+		epoch = w.epoch
+		w.mu.Unlock()
+
+		if truncated {
+			it.offset = 0
+			it.prevKey = nil
+			it.blockStart = -1
+		}
+		it.fileEnd = size
+
+		for {
+			record, err := it.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				// 读取过程中WAL被截断时重新开始，否则视为损坏
+				w.mu.Lock()
+				truncated := w.epoch != epoch
+				w.mu.Unlock()
+				if truncated {
+					break
+				}
+				return
+			}
+
+			select {
+			case ch <- *record:
+			case <-ctx.Done():
+				return
+			case <-w.done:
+				return
+			}
+		}
+
+		select {
+		case <-appended:
+		case <-ctx.Done():
+			return
+		case <-w.done:
+			return
+		}
+	}
+}
@@ -0,0 +1,173 @@
+package wal
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// 从ch接收n条记录，超时时测试失败
+func receive(t *testing.T, ch <-chan Record, n int) []Record {
+	t.Helper()
+
+	records := make([]Record, 0, n)
+	for len(records) < n {
+		select {
+		case record, ok := <-ch:
+			if !ok {
+				t.Fatalf("channel closed after %d of %d records", len(records), n)
+			}
+			records = append(records, record)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out after %d of %d records", len(records), n)
+		}
+	}
+	return records
+}
+
+// 等待ch被关闭
+func expectClosed(t *testing.T, ch <-chan Record) {
+	t.Helper()
+
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case _, ok := <-ch:
+			if !ok {
+				return
+			}
+		case <-timeout:
+			t.Fatal("channel not closed")
+		}
+	}
+}
+
+func TestTailDeliversInOrder(t *testing.T) {
+	w, err := OpenWithOptions(filepath.Join(t.TempDir(), "wal"), Options{PrefixCompression: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	records := sequentialRecords(2000)
+	if err := w.WriteBatch(records[:500]); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ch, err := w.Tail(ctx, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 开始读取之后再写入剩余的记录
+	go func() {
+		for _, record := range records[500:] {
+			if err := w.Write(record); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	}()
+
+	if got := receive(t, ch, len(records)); !sameRecords(got, records) {
+		t.Fatal("tailed records differ from written records")
+	}
+}
+
+func TestTailFromOffset(t *testing.T) {
+	w, err := OpenWithOptions(filepath.Join(t.TempDir(), "wal"), Options{PrefixCompression: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	records := sequentialRecords(1000)
+	offsets := writeRecords(t, w, records)
+
+	// 从块中间的记录开始，前缀压缩的键也能还原
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ch, err := w.Tail(ctx, offsets[700])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := receive(t, ch, 300); !sameRecords(got, records[700:]) {
+		t.Fatal("tailed records differ from written records")
+	}
+
+	// 不是记录起始位置的偏移量
+	for _, offset := range []int64{-1, offsets[700] + 1, w.End() + 1} {
+		if _, err := w.Tail(ctx, offset); !errors.Is(err, ErrInvalidOffset) {
+			t.Errorf("Tail(%d): got %v, want ErrInvalidOffset", offset, err)
+		}
+	}
+
+	// 日志末尾是合法的起点
+	if _, err := w.Tail(ctx, w.End()); err != nil {
+		t.Errorf("Tail at end: %v", err)
+	}
+}
+
+func TestTailCancel(t *testing.T) {
+	w, err := Open(filepath.Join(t.TempDir(), "wal"), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	writeRecords(t, w, sequentialRecords(10))
+
+	// 从节点不再读取时，取消ctx使后台读取退出并关闭channel
+	ctx, cancel := context.WithCancel(context.Background())
+	ch, err := w.Tail(ctx, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	receive(t, ch, 1)
+	cancel()
+	expectClosed(t, ch)
+
+	// 等待新记录时取消
+	ctx, cancel = context.WithCancel(context.Background())
+	ch, err = w.Tail(ctx, w.End())
+	if err != nil {
+		t.Fatal(err)
+	}
+	cancel()
+	expectClosed(t, ch)
+}
+
+func TestTailCloseAndTruncate(t *testing.T) {
+	w, err := Open(filepath.Join(t.TempDir(), "wal"), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ch, err := w.Tail(context.Background(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeRecords(t, w, sequentialRecords(5))
+	receive(t, ch, 5)
+
+	// 截断后从头读取新写入的记录
+	if err := w.Truncate(); err != nil {
+		t.Fatal(err)
+	}
+	record := Record{Type: TypePut, Key: []byte("after"), Value: []byte("truncate")}
+	if err := w.Write(record); err != nil {
+		t.Fatal(err)
+	}
+	got := receive(t, ch, 1)
+	if !bytes.Equal(got[0].Key, record.Key) {
+		t.Fatalf("got key %q after truncate, want %q", got[0].Key, record.Key)
+	}
+
+	w.Close()
+	expectClosed(t, ch)
+}
@@ -3,10 +3,12 @@ package wal
 import (
 	"encoding/binary"
 	"errors"
+	"fmt"
 	"hash/crc32"
 	"io"
 	"os"
 	"sync"
+	"time"
 )
 
 // 操作类型
@@ -15,18 +17,138 @@ const (
 	TypeDelete byte = 2
 )
 
+// 类型字节的最高位标记该记录的键使用了前缀压缩
+const flagPrefix byte = 0x80
+
+// 日志文件由固定大小的块组成，每条记录被切分为一个或多个片段写入块中，
+// 片段不会跨越块的边界。某个块损坏后，读取可以从下一个块的边界重新同步
+//
+// 片段格式: checksum(4字节) | length(2字节) | type(1字节) | data
+// type的低4位为片段类型，高4位为校验和算法；checksum覆盖type和data，
+// 块末尾不足一个片段头的空间用0填充
+const (
+	blockSize  = 32 * 1024
+	headerSize = 4 + 2 + 1
+)
+
+// 批量写入中所有记录键和值的默认总字节数上限
+const DefaultMaxBatchSize = 64 << 20
+
+// 片段类型
+const (
+	fragmentFull   byte = 1 // 完整的记录
+	fragmentFirst  byte = 2 // 记录的第一个片段
+	fragmentMiddle byte = 3 // 记录的中间片段
+	fragmentLast   byte = 4 // 记录的最后一个片段
+)
+
+// type字节中片段类型所占的位
+const fragmentTypeMask byte = 0x0F
+
+// 校验和算法，每个片段在type字节的高4位记录写入时使用的算法，同一个文件中可以混合使用，
+// 更换算法后旧的记录无需改写。旧格式的高4位为0，即CRC32 IEEE
+const (
+	ChecksumCRC32  byte = 0 // CRC32 IEEE
+	ChecksumCRC32C byte = 1 // CRC32 Castagnoli，支持硬件加速的平台上更快
+)
+
+var castagnoliTable = crc32.MakeTable(crc32.Castagnoli)
+
 // 错误定义
 var (
 	ErrInvalidChecksum = errors.New("invalid checksum")
 	ErrInvalidRecord   = errors.New("invalid record")
+	ErrVarintOverflow  = errors.New("varint overflows 64 bits")
+	ErrInvalidOffset   = errors.New("invalid offset")
+	ErrBatchTooLarge   = errors.New("batch too large")
+	ErrUnknownChecksum = errors.New("unknown checksum type")
 )
 
+// 数据损坏错误，记录损坏发生的文件和记录起始偏移量
+// Err为具体原因(ErrInvalidChecksum等)，可以通过errors.Is判断
+type CorruptionError struct {
+	File   string
+	Offset int64
+	Err    error
+}
+
+func (e *CorruptionError) Error() string {
+	return fmt.Sprintf("wal: corruption in %s at offset %d: %v", e.File, e.Offset, e.Err)
+}
+
+func (e *CorruptionError) Unwrap() error {
+	return e.Err
+}
+
+// 对已关闭的WAL进行操作时返回的错误
+type ClosedError struct {
+	File string
+}
+
+func (e *ClosedError) Error() string {
+	return fmt.Sprintf("wal: %s is closed", e.File)
+}
+
+// WAL 配置选项
+type Options struct {
+	SyncOps bool // 是否同步写入磁盘
+
+	// 是否启用键的前缀压缩: 每条记录只保存与上一条记录键的公共前缀长度和剩余后缀，
+	// 适用于时间戳等相邻键前缀相同的场景
+	PrefixCompression bool
+
+	// 写缓冲区大小: 记录先累积在内存中，超过该大小(或调用Flush/Sync)时才一次性写入文件，
+	// 减少小记录的系统调用次数。0表示不缓冲；SyncOps为true时每条记录都会立即写入并同步
+	// 缓冲区中尚未写入的记录在进程崩溃时会丢失；写入文件失败时记录保留在缓冲区中，由之后的写入或Flush重试
+	WriteBufferSize int
+
+	// 预分配大小: 文件空间不足时一次性扩展该大小，记录写入预留的空间中，
+	// 减少文件碎片和元数据更新。0表示不预分配
+	// 重新打开时通过扫描片段找到有效数据的末尾，正常关闭时截断多余的空间
+	PreallocateSize int64
+
+	// WriteBatch中所有记录键和值的总字节数上限，超过时拒绝整个批量并返回ErrBatchTooLarge
+	// 0表示使用DefaultMaxBatchSize
+	MaxBatchSize int64
+
+	// 写入新记录使用的校验和算法，默认为ChecksumCRC32；读取时按每个片段记录的算法校验
+	Checksum byte
+
+	// 后台同步间隔: 大于0时后台每隔该时间检查一次，有尚未同步的记录时执行SyncTo(End())，
+	// 限制不同步的写入在系统崩溃时丢失的范围。0表示不在后台同步，WAL关闭时停止
+	SyncInterval time.Duration
+}
+
 // WAL 结构体
 type WAL struct {
 	file    *os.File
 	mu      sync.Mutex
-	size    int64
-	syncOps bool // 是否同步写入磁盘
+	size    int64 // 有效数据的大小，预分配时小于文件的实际大小
+	syncOps bool  // 是否同步写入磁盘
+	closed  bool
+
+	bufferSize int
+	buf        []byte // 尚未写入文件的已编码片段
+	scratch    []byte // 编码单条记录的临时缓冲区
+
+	preallocateSize int64
+	allocated       int64 // 文件已分配的大小
+
+	maxBatchSize int64
+	checksum     byte // 写入片段使用的校验和算法
+
+	prefixCompression bool
+	lastKey           []byte // 上一条写入记录的键，用于前缀压缩
+	lastBlock         int64  // 上一条写入记录起始位置所在的块
+
+	appended chan struct{} // 有新记录写入时关闭，用于唤醒Tail，没有等待者时为nil
+	done     chan struct{} // WAL关闭时关闭
+	epoch    uint64        // 截断次数，Tail据此判断是否需要从头读取
+
+	synced   int64          // 已经同步到磁盘的日志大小
+	syncing  bool           // SyncTo正在锁外执行fsync
+	syncCond *sync.Cond     // fsync完成时唤醒等待的SyncTo和Close，使用w.mu
+	syncer   sync.WaitGroup // 后台同步的goroutine
 }
 
 // 记录结构体
@@ -38,6 +160,15 @@ type Record struct {
 
 // 打开WAL文件
 func Open(path string, syncOps bool) (*WAL, error) {
+	return OpenWithOptions(path, Options{SyncOps: syncOps})
+}
+
+// 使用配置选项打开WAL文件
+func OpenWithOptions(path string, opts Options) (*WAL, error) {
+	if _, ok := fragmentChecksum(opts.Checksum, 0, nil); !ok {
+		return nil, ErrUnknownChecksum
+	}
+
 	file, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0666)
 	if err != nil {
 		return nil, err
@@ -50,18 +181,80 @@ func Open(path string, syncOps bool) (*WAL, error) {
 		return nil, err
 	}
 
-	return &WAL{
-		file:    file,
-		size:    stat.Size(),
-		syncOps: syncOps,
-	}, nil
+	// 预分配的文件末尾是未使用的0，崩溃后末尾可能有不完整的记录，需要找到有效数据的末尾
+	// 无论本次是否开启预分配都要扫描，文件可能由开启了预分配的WAL写入
+	size, err := findLogicalEnd(file, stat.Size())
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	// 追加写入到已有记录之后
+	if _, err := file.Seek(size, io.SeekStart); err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	maxBatchSize := opts.MaxBatchSize
+	if maxBatchSize <= 0 {
+		maxBatchSize = DefaultMaxBatchSize
+	}
+
+	// 重新打开时不知道最后一条记录的键，第一条记录会写入完整的键
+	w := &WAL{
+		file:              file,
+		size:              size,
+		allocated:         stat.Size(),
+		preallocateSize:   opts.PreallocateSize,
+		syncOps:           opts.SyncOps,
+		prefixCompression: opts.PrefixCompression,
+		bufferSize:        opts.WriteBufferSize,
+		maxBatchSize:      maxBatchSize,
+		checksum:          opts.Checksum,
+		lastBlock:         -1,
+		done:              make(chan struct{}),
+	}
+	w.syncCond = sync.NewCond(&w.mu)
+	if opts.SyncInterval > 0 {
+		w.syncer.Add(1)
+		go w.syncLoop(opts.SyncInterval)
+	}
+	return w, nil
 }
 
 // 关闭WAL
 func (w *WAL) Close() error {
+	// 释放锁之后等待后台同步退出，它在拿到锁时发现WAL已关闭
+	defer w.syncer.Wait()
 	w.mu.Lock()
 	defer w.mu.Unlock()
-	return w.file.Close()
+
+	if w.closed {
+		return &ClosedError{File: w.file.Name()}
+	}
+	w.closed = true
+	close(w.done)
+
+	// 等待锁外正在执行的fsync完成后再关闭文件
+	for w.syncing {
+		w.syncCond.Wait()
+	}
+
+	// 写入缓冲区中剩余的记录，并释放预分配但未使用的空间和末尾不完整的记录
+	// 关闭前同步尚未同步的记录，不同步的写入在正常关闭后不会因系统崩溃丢失
+	err := w.flush()
+	if err == nil && w.allocated > w.size {
+		err = w.file.Truncate(w.size)
+	}
+	if err == nil && w.synced < w.size {
+		if err = w.file.Sync(); err == nil {
+			w.synced = w.size
+		}
+	}
+	if closeErr := w.file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
 }
 
 // 编码变长整数
@@ -71,61 +264,231 @@ func encodeVarint(x uint64) []byte {
 	return buf[:n]
 }
 
-// 写入一条记录
-func (w *WAL) Write(record Record) error {
-	w.mu.Lock()
-	defer w.mu.Unlock()
+// 计算两个键的公共前缀长度
+func sharedPrefixLen(a, b []byte) int {
+	n := len(a)
+	if len(b) < n {
+		n = len(b)
+	}
+	for i := 0; i < n; i++ {
+		if a[i] != b[i] {
+			return i
+		}
+	}
+	return n
+}
 
-	// 计算记录大小
-	keyLen := len(record.Key)
-	valueLen := len(record.Value)
+// 将一条记录编码后追加到buf末尾
+// 启用前缀压缩时，键只保存与prevKey的公共前缀长度和剩余后缀
+func encodeRecord(buf []byte, record Record, prevKey []byte, prefixCompression bool) []byte {
+	shared := 0
+	recordType := record.Type
+	if prefixCompression {
+		shared = sharedPrefixLen(prevKey, record.Key)
+		recordType |= flagPrefix
+	}
 
-	// 创建缓冲区
-	keyLenEncoded := encodeVarint(uint64(keyLen))
-	valueLenEncoded := encodeVarint(uint64(valueLen))
+	// 写入记录类型
+	buf = append(buf, recordType)
 
-	recordSize := 1 + len(keyLenEncoded) + len(valueLenEncoded) + keyLen + valueLen + 4
-	buf := make([]byte, recordSize)
+	// 写入公共前缀长度
+	if prefixCompression {
+		buf = append(buf, encodeVarint(uint64(shared))...)
+	}
 
-	// 写入记录类型
-	buf[0] = record.Type
+	// 写入键长度和值长度
+	buf = append(buf, encodeVarint(uint64(len(record.Key)-shared))...)
+	buf = append(buf, encodeVarint(uint64(len(record.Value)))...)
 
-	// 写入键长度
-	copy(buf[1:], keyLenEncoded)
-	offset := 1 + len(keyLenEncoded)
+	// 写入键和值
+	buf = append(buf, record.Key[shared:]...)
+	return append(buf, record.Value...)
+}
 
-	// 写入值长度
-	copy(buf[offset:], valueLenEncoded)
-	offset += len(valueLenEncoded)
+// 返回一条键长度为keyLen、值长度为valueLen的记录写入日志后最多占用的字节数，用于容量规划
+// 包括类型、长度变长整数、键、值和片段头，记录跨越块时每个片段都有一个片段头
+// 记录的起始位置决定它在哪里被块边界切开，最多比从块开头写入多一个片段头，这里按最坏情况计算；
+// 块末尾的填充(最多headerSize-1字节)也包含在内。未启用前缀压缩时实际写入的字节数
+// 不小于EntrySize-headerSize；启用前缀压缩时可能更小
+func EntrySize(keyLen, valueLen int) int64 {
+	var buf [binary.MaxVarintLen64]byte
+	payload := int64(1 + binary.PutUvarint(buf[:], uint64(keyLen)) + binary.PutUvarint(buf[:], uint64(valueLen)))
+	payload += int64(keyLen) + int64(valueLen)
+
+	fragments := (payload + blockSize - headerSize - 1) / (blockSize - headerSize)
+	return payload + (fragments+1)*headerSize
+}
+
+// 解码一条记录，prevKey为上一条记录的完整键
+func decodeRecord(data []byte, prevKey []byte) (*Record, error) {
+	if len(data) == 0 {
+		return nil, ErrInvalidRecord
+	}
 
-	// 写入键
-	copy(buf[offset:], record.Key)
-	offset += keyLen
+	recordType := data[0] &^ flagPrefix
+	if recordType != TypePut && recordType != TypeDelete {
+		return nil, ErrInvalidRecord
+	}
 
-	// 写入值
-	copy(buf[offset:], record.Value)
-	offset += valueLen
+	// 读取变长整数
+	pos := 1
+	readUvarint := func() (uint64, error) {
+		x, n := binary.Uvarint(data[pos:])
+		if n < 0 {
+			return 0, ErrVarintOverflow
+		}
+		if n == 0 {
+			return 0, ErrInvalidRecord
+		}
+		pos += n
+		return x, nil
+	}
 
-	// 计算校验和并写入
-	checksum := crc32.ChecksumIEEE(buf[:offset])
-	binary.LittleEndian.PutUint32(buf[offset:], checksum)
+	// 读取与上一条记录键的公共前缀长度
+	var shared uint64
+	if data[0]&flagPrefix != 0 {
+		var err error
+		if shared, err = readUvarint(); err != nil {
+			return nil, err
+		}
+		if shared > uint64(len(prevKey)) {
+			return nil, ErrInvalidRecord
+		}
+	}
 
-	// 写入文件
-	_, err := w.file.Write(buf)
+	// 读取键长度和值长度
+	keyLen, err := readUvarint()
 	if err != nil {
-		return err
+		return nil, err
+	}
+	valueLen, err := readUvarint()
+	if err != nil {
+		return nil, err
 	}
 
-	// 如果需要同步写入磁盘
-	if w.syncOps {
-		if err := w.file.Sync(); err != nil {
-			return err
+	// 键和值必须恰好占满剩余的数据
+	remaining := uint64(len(data) - pos)
+	if keyLen > remaining || valueLen != remaining-keyLen {
+		return nil, ErrInvalidRecord
+	}
+
+	// 还原完整的键
+	key := make([]byte, int(shared)+int(keyLen))
+	copy(key, prevKey[:shared])
+	copy(key[shared:], data[pos:pos+int(keyLen)])
+	pos += int(keyLen)
+
+	value := make([]byte, valueLen)
+	copy(value, data[pos:])
+
+	return &Record{
+		Type:  recordType,
+		Key:   key,
+		Value: value,
+	}, nil
+}
+
+// 日志的逻辑末尾，包含缓冲区中尚未写入文件的数据，调用方需持有w.mu
+func (w *WAL) end() int64 {
+	return w.size + int64(len(w.buf))
+}
+
+// 编码一条记录并切分为片段追加到缓冲区，调用方需持有w.mu
+func (w *WAL) appendRecord(record Record) {
+	// 计算记录的起始位置，块末尾放不下片段头时从下一个块开始
+	start := w.end()
+	if blockSize-start%blockSize < headerSize {
+		start += blockSize - start%blockSize
+	}
+
+	// 每个块中第一条开始的记录写入完整的键，
+	// 这样从块边界重新同步后，读取方可以还原后续记录的键
+	block := start / blockSize
+	if block != w.lastBlock {
+		w.lastKey = w.lastKey[:0]
+	}
+	w.lastBlock = block
+
+	w.scratch = encodeRecord(w.scratch[:0], record, w.lastKey, w.prefixCompression)
+	w.appendFragments(w.scratch)
+
+	if w.prefixCompression {
+		w.lastKey = append(w.lastKey[:0], record.Key...)
+	}
+}
+
+// 将编码后的记录切分为片段追加到缓冲区，调用方需持有w.mu
+func (w *WAL) appendFragments(data []byte) {
+	first := true
+	for {
+		// 块剩余空间放不下片段头时填充0
+		left := blockSize - int(w.end()%blockSize)
+		if left < headerSize {
+			w.buf = append(w.buf, make([]byte, left)...)
+			left = blockSize
+		}
+
+		n := left - headerSize
+		if n > len(data) {
+			n = len(data)
+		}
+		last := n == len(data)
+
+		var fragmentType byte
+		switch {
+		case first && last:
+			fragmentType = fragmentFull
+		case first:
+			fragmentType = fragmentFirst
+		case last:
+			fragmentType = fragmentLast
+		default:
+			fragmentType = fragmentMiddle
+		}
+
+		// 写入片段头和数据
+		typeByte := w.checksum<<4 | fragmentType
+		checksum, _ := fragmentChecksum(w.checksum, typeByte, data[:n])
+		w.buf = binary.LittleEndian.AppendUint32(w.buf, checksum)
+		w.buf = binary.LittleEndian.AppendUint16(w.buf, uint16(n))
+		w.buf = append(w.buf, typeByte)
+		w.buf = append(w.buf, data[:n]...)
+
+		data = data[n:]
+		first = false
+		if last {
+			return
 		}
 	}
+}
 
-	// 更新文件大小
-	w.size += int64(recordSize)
-	return nil
+// 使用checksumType指定的算法计算片段的校验和，覆盖type字节和数据
+// 不支持的算法返回false
+func fragmentChecksum(checksumType, typeByte byte, data []byte) (uint32, bool) {
+	var table *crc32.Table
+	switch checksumType {
+	case ChecksumCRC32:
+		table = crc32.IEEETable
+	case ChecksumCRC32C:
+		table = castagnoliTable
+	default:
+		return 0, false
+	}
+	return crc32.Update(crc32.Checksum([]byte{typeByte}, table), table, data), true
+}
+
+// 写入一条记录
+func (w *WAL) Write(record Record) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.closed {
+		return &ClosedError{File: w.file.Name()}
+	}
+
+	mark := len(w.buf)
+	w.appendRecord(record)
+	return w.maybeFlush(mark)
 }
 
 // 批量写入记录
@@ -133,74 +496,150 @@ func (w *WAL) WriteBatch(records []Record) error {
 	w.mu.Lock()
 	defer w.mu.Unlock()
 
-	// 计算总大小并分配缓冲区
-	totalSize := 0
-	for _, record := range records {
-		keyLenEncoded := encodeVarint(uint64(len(record.Key)))
-		valueLenEncoded := encodeVarint(uint64(len(record.Value)))
-		totalSize += 1 + len(keyLenEncoded) + len(valueLenEncoded) + len(record.Key) + len(record.Value) + 4
+	if w.closed {
+		return &ClosedError{File: w.file.Name()}
 	}
 
-	buf := make([]byte, totalSize)
-	offset := 0
-
-	// 写入所有记录
+	// 先检查总大小，超过上限时不写入任何记录
+	// 使用int64累加，避免在32位平台上溢出
+	var total int64
 	for _, record := range records {
-		keyLen := len(record.Key)
-		valueLen := len(record.Value)
-		keyLenEncoded := encodeVarint(uint64(keyLen))
-		valueLenEncoded := encodeVarint(uint64(valueLen))
-
-		// 写入记录类型
-		buf[offset] = record.Type
-		offset++
+		total += int64(len(record.Key)) + int64(len(record.Value))
+		if total > w.maxBatchSize {
+			return fmt.Errorf("wal: %w: more than %d bytes", ErrBatchTooLarge, w.maxBatchSize)
+		}
+	}
 
-		// 写入键长度
-		copy(buf[offset:], keyLenEncoded)
-		offset += len(keyLenEncoded)
+	// 编码所有记录
+	mark := len(w.buf)
+	for _, record := range records {
+		w.appendRecord(record)
+	}
+	return w.maybeFlush(mark)
+}
 
-		// 写入值长度
-		copy(buf[offset:], valueLenEncoded)
-		offset += len(valueLenEncoded)
+// 缓冲区超过大小或需要同步写入时写入文件，调用方需持有w.mu
+// 写入文件失败时丢弃缓冲区中mark之后本次追加的记录，调用方会收到错误；
+// 之前已经成功返回的记录保留在缓冲区中，下次写入文件时重试，不会丢失
+func (w *WAL) maybeFlush(mark int) error {
+	if !w.syncOps && len(w.buf) < w.bufferSize {
+		return nil
+	}
 
-		// 写入键
-		copy(buf[offset:], record.Key)
-		offset += keyLen
+	err := w.flush()
+	if err != nil && len(w.buf) > mark {
+		w.buf = w.buf[:mark]
+		// 被丢弃的记录可能是之后记录前缀压缩的基准，下一条记录写入完整的键
+		w.lastKey = nil
+		w.lastBlock = -1
+	}
+	return err
+}
 
-		// 写入值
-		copy(buf[offset:], record.Value)
-		offset += valueLen
+// 将缓冲区写入文件，调用方需持有w.mu
+func (w *WAL) flush() error {
+	if len(w.buf) == 0 {
+		return nil
+	}
 
-		// 计算校验和
-		recordSize := 1 + len(keyLenEncoded) + len(valueLenEncoded) + keyLen + valueLen
-		checksum := crc32.ChecksumIEEE(buf[offset-recordSize : offset])
-		binary.LittleEndian.PutUint32(buf[offset:offset+4], checksum)
-		offset += 4
+	// 预留的空间不足时扩展文件
+	if err := w.reserve(w.size + int64(len(w.buf))); err != nil {
+		return err
 	}
 
-	// 写入文件
-	_, err := w.file.Write(buf)
-	if err != nil {
+	// 写入文件，失败时保留缓冲区，之后可以重试
+	// 部分写入的数据不计入有效大小，将写入位置移回有效数据的末尾，重试时会覆盖它们，
+	// 否则文件位置与size不一致，之后的片段会错开块的边界
+	if err := writeFull(w.file, w.buf); err != nil {
+		if _, seekErr := w.file.Seek(w.size, io.SeekStart); seekErr != nil {
+			return seekErr
+		}
 		return err
 	}
 
+	// 更新文件大小，数据已经写入文件，即使之后同步失败也不能再重复写入
+	w.size += int64(len(w.buf))
+	w.buf = w.buf[:0]
+	w.notifyAppended()
+
 	// 如果需要同步写入磁盘
 	if w.syncOps {
 		if err := w.file.Sync(); err != nil {
 			return err
 		}
+		w.synced = w.size
+	}
+	return nil
+}
+
+// 写入全部数据，Write返回的字节数不足时继续写入剩余的部分
+func writeFull(dst io.Writer, data []byte) error {
+	for len(data) > 0 {
+		n, err := dst.Write(data)
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			return io.ErrShortWrite
+		}
+		data = data[n:]
+	}
+	return nil
+}
+
+// 将缓冲区中的记录写入文件
+func (w *WAL) Flush() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.closed {
+		return &ClosedError{File: w.file.Name()}
 	}
+	return w.flush()
+}
 
-	// 更新文件大小
-	w.size += int64(totalSize)
+// 将缓冲区中的记录写入文件并同步到磁盘
+func (w *WAL) Sync() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.closed {
+		return &ClosedError{File: w.file.Name()}
+	}
+	if err := w.flush(); err != nil {
+		return err
+	}
+	if err := w.file.Sync(); err != nil {
+		return err
+	}
+	w.synced = w.size
 	return nil
 }
 
 // 从WAL重建MemTable的迭代器
+// 遇到损坏的数据时Next返回*CorruptionError，继续调用Next读取之后完好的记录：
+// 片段头完好只有数据校验失败时跳过该片段，片段头无效时从下一个块的边界重新同步
 type Iterator struct {
 	file    *os.File
-	offset  int64
+	offset  int64 // 下一个片段在文件中的位置
 	fileEnd int64
+	prevKey []byte // 上一条记录的键，用于还原前缀压缩的键
+	lastPos int64  // 上一次Next返回的记录的起始位置
+
+	block      []byte // 当前块的数据
+	blockStart int64  // 当前块在文件中的起始位置，-1表示尚未读取
+	resync     bool   // 跳过损坏的数据后，丢弃不完整记录的剩余片段
+	keyLost    bool   // 块中间跳过了损坏的片段，之后前缀压缩的键无法还原
+}
+
+// 创建从offset开始读取到fileEnd的迭代器
+func newIterator(file *os.File, offset, fileEnd int64) *Iterator {
+	return &Iterator{
+		file:       file,
+		offset:     offset,
+		fileEnd:    fileEnd,
+		blockStart: -1,
+	}
 }
 
 // 创建迭代器
@@ -208,134 +647,250 @@ func (w *WAL) NewIterator() (*Iterator, error) {
 	w.mu.Lock()
 	defer w.mu.Unlock()
 
+	if w.closed {
+		return nil, &ClosedError{File: w.file.Name()}
+	}
+
+	// 迭代器只能读到文件中的记录
+	if err := w.flush(); err != nil {
+		return nil, err
+	}
+
 	// 复制文件句柄以便并行读取
 	f, err := os.Open(w.file.Name())
 	if err != nil {
 		return nil, err
 	}
 
-	return &Iterator{
-		file:    f,
-		offset:  0,
-		fileEnd: w.size,
-	}, nil
+	return newIterator(f, 0, w.size), nil
 }
 
-// 读取变长整数
-func readUvarint(r io.Reader) (uint64, int, error) {
-	var x uint64
-	var s uint
-	var b byte
-	var err error
+// 返回offset处的CorruptionError，并跳到下一个块的边界重新同步
+func (it *Iterator) corruption(offset int64, err error) error {
+	it.offset = offset - offset%blockSize + blockSize
+	it.prevKey = nil
+	it.resync = true
+	it.keyLost = false
+	return &CorruptionError{
+		File:   it.file.Name(),
+		Offset: offset,
+		Err:    err,
+	}
+}
 
-	buf := make([]byte, 1)
-	for i := 0; ; i++ {
-		_, err = r.Read(buf)
-		if err != nil {
-			return 0, 0, err
+// 返回offset处的CorruptionError，跳过该片段从next继续读取
+// 损坏的片段可能是下一条记录前缀压缩所依赖的键，因此丢弃上一条记录的键
+func (it *Iterator) skipFragment(offset, next int64, err error) error {
+	it.offset = next
+	it.prevKey = nil
+	it.resync = true
+	it.keyLost = true
+	return &CorruptionError{
+		File:   it.file.Name(),
+		Offset: offset,
+		Err:    err,
+	}
+}
+
+// 读取下一个片段，返回片段类型、数据和片段在文件中的位置
+func (it *Iterator) readFragment() (byte, []byte, int64, error) {
+	for {
+		if it.offset >= it.fileEnd {
+			return 0, nil, 0, io.EOF
+		}
+
+		// 块剩余空间不足一个片段头时是填充数据，跳到下一个块
+		pos := int(it.offset % blockSize)
+		if blockSize-pos < headerSize {
+			it.offset += int64(blockSize - pos)
+			continue
 		}
-		b = buf[0]
 
-		if b < 0x80 {
-			if i > 9 || i == 9 && b > 1 {
-				return 0, 0, errors.New("binary: varint overflows 64 bits")
+		// 读取片段所在的块，日志变长后需要重新读取最后一个不完整的块
+		blockStart := it.offset - int64(pos)
+		blockLen := int64(blockSize)
+		if it.fileEnd-blockStart < blockLen {
+			blockLen = it.fileEnd - blockStart
+		}
+		if it.blockStart != blockStart || int64(len(it.block)) < blockLen {
+			if cap(it.block) < blockSize {
+				it.block = make([]byte, blockSize)
 			}
-			return x | uint64(b)<<s, i + 1, nil
+			it.block = it.block[:blockLen]
+			if _, err := it.file.ReadAt(it.block, blockStart); err != nil {
+				it.blockStart = -1
+				return 0, nil, 0, err
+			}
+			it.blockStart = blockStart
+		}
+
+		// 解析片段头
+		offset := it.offset
+		if pos+headerSize > len(it.block) {
+			return 0, nil, 0, it.corruption(offset, ErrInvalidRecord)
+		}
+		header := it.block[pos : pos+headerSize]
+		if isZeroHeader(header) {
+			// 预分配的空间，跳到下一个块
+			it.offset += int64(blockSize - pos)
+			continue
 		}
-		x |= uint64(b&0x7f) << s
-		s += 7
+		checksum := binary.LittleEndian.Uint32(header[0:4])
+		length := int(binary.LittleEndian.Uint16(header[4:6]))
+		fragmentType := header[6] & fragmentTypeMask
+
+		end := pos + headerSize + length
+		if end > len(it.block) {
+			return 0, nil, 0, it.corruption(offset, ErrInvalidRecord)
+		}
+		data := it.block[pos+headerSize : end]
+
+		// 按片段记录的算法校验，未知的算法说明片段头已损坏
+		expected, ok := fragmentChecksum(header[6]>>4, header[6], data)
+		if !ok {
+			return 0, nil, 0, it.corruption(offset, ErrInvalidChecksum)
+		}
+		if expected != checksum {
+			if fragmentType < fragmentFull || fragmentType > fragmentLast {
+				return 0, nil, 0, it.corruption(offset, ErrInvalidChecksum)
+			}
+			// 片段头完好，只跳过损坏的数据，块中之后的片段仍然可以读取
+			return 0, nil, 0, it.skipFragment(offset, offset+int64(headerSize+length), ErrInvalidChecksum)
+		}
+
+		it.offset += int64(headerSize + length)
+		return fragmentType, data, offset, nil
 	}
 }
 
 // 迭代获取下一条记录
 func (it *Iterator) Next() (*Record, error) {
-	// 检查是否到文件末尾
-	if it.offset >= it.fileEnd {
-		return nil, io.EOF
-	}
-
-	// 定位到偏移位置
-	_, err := it.file.Seek(it.offset, 0)
-	if err != nil {
-		return nil, err
-	}
+	var data []byte
+	var recordStart int64
+	inRecord := false
+
+	for {
+		fragmentType, fragment, offset, err := it.readFragment()
+		if err == io.EOF && inRecord {
+			// 日志末尾只写入了一部分的记录
+			return nil, it.corruption(recordStart, ErrInvalidRecord)
+		}
+		if err != nil {
+			return nil, err
+		}
 
-	// 读取记录类型
-	typeBuf := make([]byte, 1)
-	_, err = it.file.Read(typeBuf)
-	if err != nil {
-		return nil, err
-	}
+		switch fragmentType {
+		case fragmentFull, fragmentFirst:
+			// 上一条记录缺少结尾，先报告损坏，下次从当前片段继续读取
+			if inRecord {
+				err := it.corruption(recordStart, ErrInvalidRecord)
+				it.offset = offset
+				return nil, err
+			}
+			it.resync = false
+			recordStart = offset
+			data = append(data[:0], fragment...)
+			if fragmentType == fragmentFirst {
+				inRecord = true
+				continue
+			}
 
-	recordType := typeBuf[0]
-	if recordType != TypePut && recordType != TypeDelete {
-		return nil, ErrInvalidRecord
-	}
+		case fragmentMiddle, fragmentLast:
+			if !inRecord {
+				// 重新同步时丢弃之前记录的剩余片段
+				if it.resync {
+					continue
+				}
+				return nil, it.corruption(offset, ErrInvalidRecord)
+			}
+			data = append(data, fragment...)
+			if fragmentType == fragmentMiddle {
+				continue
+			}
 
-	// 读取键长度
-	keyLen, keyLenSize, err := readUvarint(it.file)
-	if err != nil {
-		return nil, err
-	}
+		default:
+			return nil, it.corruption(offset, ErrInvalidRecord)
+		}
 
-	// 读取值长度
-	valueLen, valueLenSize, err := readUvarint(it.file)
-	if err != nil {
-		return nil, err
+		record, err := decodeRecord(data, it.prevKey)
+		if err != nil && it.keyLost && it.prevKey == nil && len(data) > 0 && data[0]&flagPrefix != 0 {
+			// 依赖的键在之前跳过的片段中，损坏已经报告过，丢弃该块剩余的记录
+			if pos := it.offset % blockSize; pos != 0 {
+				it.offset += blockSize - pos
+			}
+			it.resync = true
+			it.keyLost = false
+			inRecord = false
+			continue
+		}
+		if err != nil {
+			return nil, it.corruption(recordStart, err)
+		}
+		it.prevKey = record.Key
+		it.lastPos = recordStart
+		return record, nil
 	}
+}
 
-	// 读取键
-	key := make([]byte, keyLen)
-	_, err = io.ReadFull(it.file, key)
-	if err != nil {
-		return nil, err
-	}
+// 回到日志开头重新读取，复用已打开的文件
+func (it *Iterator) Reset() {
+	it.offset = 0
+	it.prevKey = nil
+	it.resync = false
+	it.keyLost = false
+}
 
-	// 读取值
-	value := make([]byte, valueLen)
-	_, err = io.ReadFull(it.file, value)
-	if err != nil {
-		return nil, err
+// 跳转到offset处的记录，offset必须是一条记录的起始位置或日志末尾，否则返回ErrInvalidOffset
+// 从offset所在块的开头逐条读取到offset，以校验位置并还原前缀压缩所需的上一条记录的键
+func (it *Iterator) SeekTo(offset int64) error {
+	if offset < 0 || offset > it.fileEnd {
+		return ErrInvalidOffset
 	}
 
-	// 读取校验和
-	checksumBuf := make([]byte, 4)
-	_, err = io.ReadFull(it.file, checksumBuf)
-	if err != nil {
-		return nil, err
+	// 失败时恢复原来的位置
+	savedOffset, savedPrevKey, savedResync, savedKeyLost := it.offset, it.prevKey, it.resync, it.keyLost
+	fail := func(err error) error {
+		it.offset, it.prevKey, it.resync, it.keyLost = savedOffset, savedPrevKey, savedResync, savedKeyLost
+		return err
 	}
 
-	// 计算记录大小
-	recordSize := 1 + keyLenSize + valueLenSize + int(keyLen) + int(valueLen) + 4
+	it.offset = offset - offset%blockSize
+	it.prevKey = nil
+	it.resync = false
+	it.keyLost = false
 
-	// 验证校验和
-	// 需要重新计算前面的数据的校验和
-	_, err = it.file.Seek(it.offset, 0)
-	if err != nil {
-		return nil, err
+	// 跳过块开头属于之前记录的片段
+	for it.offset < offset {
+		start := it.offset
+		fragmentType, _, _, err := it.readFragment()
+		if err != nil {
+			return fail(ErrInvalidOffset)
+		}
+		if fragmentType == fragmentFull || fragmentType == fragmentFirst {
+			it.offset = start
+			break
+		}
 	}
 
-	data := make([]byte, recordSize-4)
-	_, err = io.ReadFull(it.file, data)
-	if err != nil {
-		return nil, err
+	// 逐条读取块中offset之前的记录
+	for it.offset < offset {
+		if _, err := it.Next(); err != nil {
+			return fail(ErrInvalidOffset)
+		}
 	}
-
-	checksum := crc32.ChecksumIEEE(data)
-	readChecksum := binary.LittleEndian.Uint32(checksumBuf)
-
-	if checksum != readChecksum {
-		return nil, ErrInvalidChecksum
+	if it.offset != offset {
+		return fail(ErrInvalidOffset)
 	}
 
-	// 更新偏移量
-	it.offset += int64(recordSize)
-
-	return &Record{
-		Type:  recordType,
-		Key:   key,
-		Value: value,
-	}, nil
+	// offset处必须是一条记录的开始
+	if offset < it.fileEnd {
+		fragmentType, _, _, err := it.readFragment()
+		it.offset = offset
+		if err != nil || (fragmentType != fragmentFull && fragmentType != fragmentFirst) {
+			return fail(ErrInvalidOffset)
+		}
+	}
+	return nil
 }
 
 // 关闭迭代器
@@ -348,14 +903,25 @@ func (w *WAL) Truncate() error {
 	w.mu.Lock()
 	defer w.mu.Unlock()
 
+	if w.closed {
+		return &ClosedError{File: w.file.Name()}
+	}
+
 	if err := w.file.Truncate(0); err != nil {
 		return err
 	}
+	w.allocated = 0
 
 	if _, err := w.file.Seek(0, 0); err != nil {
 		return err
 	}
 
 	w.size = 0
+	w.synced = 0
+	w.buf = w.buf[:0]
+	w.lastKey = nil
+	w.lastBlock = -1
+	w.epoch++
+	w.notifyAppended()
 	return nil
 }
@@ -0,0 +1,886 @@
+package wal
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+)
+
+// 读取WAL中的所有记录，遇到任何错误时测试失败
+func readAll(t *testing.T, w *WAL) []Record {
+	t.Helper()
+
+	it, err := w.NewIterator()
+	if err != nil {
+		t.Fatalf("NewIterator: %v", err)
+	}
+	defer it.Close()
+
+	var records []Record
+	for {
+		record, err := it.Next()
+		if err == io.EOF {
+			return records
+		}
+		if err != nil {
+			t.Fatalf("Next: %v", err)
+		}
+		records = append(records, *record)
+	}
+}
+
+func sameRecords(a, b []Record) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].Type != b[i].Type || !bytes.Equal(a[i].Key, b[i].Key) || !bytes.Equal(a[i].Value, b[i].Value) {
+			return false
+		}
+	}
+	return true
+}
+
+// 键前缀相同的一组记录，跨越多个块
+func sequentialRecords(n int) []Record {
+	records := make([]Record, n)
+	for i := range records {
+		records[i] = Record{
+			Type:  TypePut,
+			Key:   []byte(fmt.Sprintf("sensor/temperature/2024-01-01T00:00:%08d", i)),
+			Value: []byte(fmt.Sprintf("value-%d", i)),
+		}
+		if i%7 == 0 {
+			records[i] = Record{Type: TypeDelete, Key: records[i].Key}
+		}
+	}
+	return records
+}
+
+func fileSize(t *testing.T, path string) int64 {
+	t.Helper()
+
+	stat, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return stat.Size()
+}
+
+func TestPrefixCompression(t *testing.T) {
+	dir := t.TempDir()
+	records := sequentialRecords(5000)
+
+	sizes := map[bool]int64{}
+	for _, compress := range []bool{false, true} {
+		path := filepath.Join(dir, fmt.Sprintf("wal-%v", compress))
+		w, err := OpenWithOptions(path, Options{PrefixCompression: compress})
+		if err != nil {
+			t.Fatal(err)
+		}
+		// 分两次写入，覆盖Write和WriteBatch两条路径
+		for _, record := range records[:100] {
+			if err := w.Write(record); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := w.WriteBatch(records[100:]); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		sizes[compress] = fileSize(t, path)
+
+		// 重新打开后按顺序还原出完整的键
+		w, err = Open(path, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := readAll(t, w); !sameRecords(got, records) {
+			t.Fatalf("compress=%v: replayed records differ from written records", compress)
+		}
+		w.Close()
+	}
+
+	if sizes[true] >= sizes[false]*2/3 {
+		t.Fatalf("compressed WAL is %d bytes, uncompressed %d bytes", sizes[true], sizes[false])
+	}
+}
+
+func TestPrefixCompressionAcrossReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "wal")
+	records := sequentialRecords(300)
+
+	// 每次重新打开后第一条记录写入完整的键
+	for i := 0; i < len(records); i += 100 {
+		w, err := OpenWithOptions(path, Options{PrefixCompression: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := w.WriteBatch(records[i : i+100]); err != nil {
+			t.Fatal(err)
+		}
+		w.Close()
+	}
+
+	w, err := Open(path, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	if got := readAll(t, w); !sameRecords(got, records) {
+		t.Fatal("replayed records differ from written records")
+	}
+}
+
+// 写入记录并返回每条记录的起始位置
+func writeRecords(t *testing.T, w *WAL, records []Record) []int64 {
+	t.Helper()
+
+	offsets := make([]int64, len(records))
+	for i, record := range records {
+		// 块末尾不足一个片段头时记录从下一个块开始
+		offsets[i] = w.End()
+		if rest := blockSize - offsets[i]%blockSize; rest < headerSize {
+			offsets[i] += rest
+		}
+		if err := w.Write(record); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return offsets
+}
+
+// 修改文件中offset处的一个字节
+func flipByte(t *testing.T, path string, offset int64) {
+	t.Helper()
+
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	b := make([]byte, 1)
+	if _, err := f.ReadAt(b, offset); err != nil {
+		t.Fatal(err)
+	}
+	b[0] ^= 0xFF
+	if _, err := f.WriteAt(b, offset); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCorruptionError(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "wal")
+	w, err := Open(path, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	offsets := writeRecords(t, w, sequentialRecords(3))
+	w.Close()
+
+	// 破坏第二条记录的数据
+	flipByte(t, path, offsets[1]+headerSize+1)
+
+	w, err = Open(path, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	it, err := w.NewIterator()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer it.Close()
+
+	if _, err := it.Next(); err != nil {
+		t.Fatalf("first record: %v", err)
+	}
+	_, err = it.Next()
+
+	var corruption *CorruptionError
+	if !errors.As(err, &corruption) {
+		t.Fatalf("got %v, want *CorruptionError", err)
+	}
+	if corruption.File != path || corruption.Offset != offsets[1] {
+		t.Fatalf("got file %q offset %d, want %q offset %d", corruption.File, corruption.Offset, path, offsets[1])
+	}
+	if !errors.Is(err, ErrInvalidChecksum) {
+		t.Fatalf("got %v, want ErrInvalidChecksum", err)
+	}
+}
+
+func TestClosedError(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "wal")
+	w, err := Open(path, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	checks := map[string]error{
+		"Write":      w.Write(Record{Type: TypePut, Key: []byte("k")}),
+		"WriteBatch": w.WriteBatch(nil),
+		"Flush":      w.Flush(),
+		"Sync":       w.Sync(),
+		"Truncate":   w.Truncate(),
+		"Close":      w.Close(),
+	}
+	for name, err := range checks {
+		var closed *ClosedError
+		if !errors.As(err, &closed) || closed.File != path {
+			t.Errorf("%s: got %v, want *ClosedError for %s", name, err, path)
+		}
+	}
+}
+
+// 将WAL的文件替换为只读的句柄，之后写入文件都会失败，返回恢复原文件的函数
+func failWrites(t *testing.T, w *WAL) func() {
+	t.Helper()
+
+	f, err := os.Open(w.file.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Seek(w.size, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	original := w.file
+	w.file = f
+	return func() {
+		w.file = original
+		f.Close()
+	}
+}
+
+func TestFlushFailureKeepsBuffer(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "wal")
+	w, err := OpenWithOptions(path, Options{WriteBufferSize: 1 << 20, PrefixCompression: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	records := sequentialRecords(100)
+	writeRecords(t, w, records[:50])
+
+	restore := failWrites(t, w)
+	if err := w.Flush(); err == nil {
+		t.Fatal("Flush succeeded on a read-only file")
+	}
+	restore()
+
+	// 之前成功返回的记录仍在缓冲区中，重试后与之后的记录一起写入
+	writeRecords(t, w, records[50:])
+	if err := w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if got := readAll(t, w); !sameRecords(got, records) {
+		t.Fatalf("got %d records, want %d", len(got), len(records))
+	}
+}
+
+func TestFailedWriteDropsOnlyItsRecord(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "wal")
+	w, err := OpenWithOptions(path, Options{PrefixCompression: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	records := sequentialRecords(4)
+	writeRecords(t, w, records[:2])
+
+	restore := failWrites(t, w)
+	if err := w.Write(records[2]); err == nil {
+		t.Fatal("Write succeeded on a read-only file")
+	}
+	restore()
+
+	// 失败的记录不会写入，之后的记录不能以它为前缀压缩的基准
+	if err := w.Write(records[3]); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	w, err = Open(path, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	want := []Record{records[0], records[1], records[3]}
+	if got := readAll(t, w); !sameRecords(got, want) {
+		t.Fatalf("got %+v, want %+v", got, want)
+	}
+}
+
+func BenchmarkWriteSmall(b *testing.B) {
+	for _, bufferSize := range []int{0, 64 << 10} {
+		b.Run(fmt.Sprintf("buffer=%d", bufferSize), func(b *testing.B) {
+			w, err := OpenWithOptions(filepath.Join(b.TempDir(), "wal"), Options{WriteBufferSize: bufferSize})
+			if err != nil {
+				b.Fatal(err)
+			}
+			defer w.Close()
+
+			record := Record{Type: TypePut, Key: []byte("key-00000000"), Value: []byte("value")}
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if err := w.Write(record); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestResyncAfterCorruptBlock(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "wal")
+	w, err := OpenWithOptions(path, Options{PrefixCompression: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	records := sequentialRecords(5000)
+	offsets := writeRecords(t, w, records)
+	w.Close()
+
+	// 破坏第二个块中间的一条记录
+	bad := sort.Search(len(offsets), func(i int) bool { return offsets[i] >= blockSize+blockSize/2 })
+	flipByte(t, path, offsets[bad]+headerSize+1)
+
+	w, err = Open(path, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	it, err := w.NewIterator()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer it.Close()
+
+	recovered := map[string]bool{}
+	corruptions := 0
+	for {
+		record, err := it.Next()
+		if err == io.EOF {
+			break
+		}
+		var corruption *CorruptionError
+		if errors.As(err, &corruption) {
+			if corruption.Offset != offsets[bad] {
+				t.Errorf("corruption at offset %d, want %d", corruption.Offset, offsets[bad])
+			}
+			corruptions++
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		recovered[string(record.Key)] = true
+	}
+
+	if corruptions != 1 {
+		t.Fatalf("got %d corruptions, want 1", corruptions)
+	}
+	// 损坏之前的记录和之后块中的记录都能恢复，损坏块中剩余的记录依赖被破坏的前缀压缩键而丢失
+	for i, record := range records {
+		want := i < bad || offsets[i] >= 2*blockSize
+		if i > bad && offsets[i] < 2*blockSize {
+			continue
+		}
+		if recovered[string(record.Key)] != want {
+			t.Fatalf("record %d at offset %d: recovered %v, want %v", i, offsets[i], !want, want)
+		}
+	}
+}
+
+func copyFile(t *testing.T, src, dst string) {
+	t.Helper()
+
+	data, err := os.ReadFile(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(dst, data, 0666); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRecoverPreallocated(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "wal")
+	w, err := OpenWithOptions(path, Options{PreallocateSize: 1 << 20})
+	if err != nil {
+		t.Fatal(err)
+	}
+	records := sequentialRecords(1000)
+	writeRecords(t, w, records[:500])
+	end := w.End()
+
+	// 不关闭WAL直接复制文件，模拟崩溃后留下的预分配空间
+	crashed := filepath.Join(dir, "crashed")
+	copyFile(t, path, crashed)
+	w.Close()
+	if size := fileSize(t, crashed); size != 1<<20 {
+		t.Fatalf("crashed file size %d, want %d", size, 1<<20)
+	}
+
+	// 不开启预分配也能找到有效数据的末尾，新的记录写在旧记录之后而不是0之后
+	w, err = Open(crashed, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w.End() != end {
+		t.Fatalf("reopened at %d, want %d", w.End(), end)
+	}
+	if got := readAll(t, w); !sameRecords(got, records[:500]) {
+		t.Fatalf("got %d records, want 500", len(got))
+	}
+	writeRecords(t, w, records[500:])
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	w, err = Open(crashed, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	if got := readAll(t, w); !sameRecords(got, records) {
+		t.Fatalf("got %d records, want %d", len(got), len(records))
+	}
+	if size := fileSize(t, crashed); size != w.End() {
+		t.Fatalf("file size %d after close, want %d", size, w.End())
+	}
+}
+
+func TestRecoverTornTail(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "wal")
+	w, err := Open(path, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	records := sequentialRecords(100)
+	offsets := writeRecords(t, w, records)
+	w.Close()
+
+	// 最后一条记录只写入了一部分
+	if err := os.Truncate(path, offsets[99]+headerSize+3); err != nil {
+		t.Fatal(err)
+	}
+
+	w, err = Open(path, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w.End() != offsets[99] {
+		t.Fatalf("reopened at %d, want %d", w.End(), offsets[99])
+	}
+	if got := readAll(t, w); !sameRecords(got, records[:99]) {
+		t.Fatalf("got %d records, want 99", len(got))
+	}
+	writeRecords(t, w, records[99:])
+	w.Close()
+
+	w, err = Open(path, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	if got := readAll(t, w); !sameRecords(got, records) {
+		t.Fatalf("got %d records, want %d", len(got), len(records))
+	}
+}
+
+func TestOpenUnrecognizedLog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "wal")
+	if err := os.WriteFile(path, []byte("PUT key value\nPUT other value\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := Open(path, false)
+	var corruption *CorruptionError
+	if !errors.As(err, &corruption) || corruption.Offset != 0 {
+		t.Fatalf("got %v, want *CorruptionError at offset 0", err)
+	}
+}
+
+// 片段头完好、只有数据校验失败的日志不是其他格式，可以打开
+func TestOpenCorruptFirstRecord(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "wal")
+	w, err := Open(path, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Write(Record{Type: TypePut, Key: []byte("key"), Value: []byte("value")}); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	flipByte(t, path, headerSize+1)
+
+	w, err = Open(path, false)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer w.Close()
+	if err := w.Write(Record{Type: TypePut, Key: []byte("next"), Value: []byte("value")}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// 从迭代器当前位置读取剩余的所有记录
+func readRest(t *testing.T, it *Iterator) []Record {
+	t.Helper()
+
+	var records []Record
+	for {
+		record, err := it.Next()
+		if err == io.EOF {
+			return records
+		}
+		if err != nil {
+			t.Fatalf("Next: %v", err)
+		}
+		records = append(records, *record)
+	}
+}
+
+func TestIteratorResetAndSeek(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "wal")
+	w, err := OpenWithOptions(path, Options{PrefixCompression: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	records := sequentialRecords(3000)
+	// 加入跨越块边界的大记录
+	records[1500].Value = bytes.Repeat([]byte("v"), blockSize+100)
+	offsets := writeRecords(t, w, records)
+	end := w.End()
+
+	it, err := w.NewIterator()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer it.Close()
+
+	// 重新读取得到相同的记录
+	for pass := 0; pass < 2; pass++ {
+		if got := readRest(t, it); !sameRecords(got, records) {
+			t.Fatalf("pass %d: got %d records, want %d", pass, len(got), len(records))
+		}
+		it.Reset()
+	}
+
+	// 跳转到记录的起始位置，包括每个块中的第一条记录和日志末尾
+	for _, i := range []int{0, 1, 7, 1499, 1500, 1501, 2999} {
+		if err := it.SeekTo(offsets[i]); err != nil {
+			t.Fatalf("SeekTo(%d): %v", offsets[i], err)
+		}
+		if got := readRest(t, it); !sameRecords(got, records[i:]) {
+			t.Fatalf("SeekTo record %d: got %d records, want %d", i, len(got), len(records)-i)
+		}
+	}
+	if err := it.SeekTo(end); err != nil {
+		t.Fatalf("SeekTo(end): %v", err)
+	}
+	if _, err := it.Next(); err != io.EOF {
+		t.Fatalf("Next at end: got %v, want EOF", err)
+	}
+
+	// 不是记录起始位置的offset被拒绝，迭代器位置不变
+	if err := it.SeekTo(offsets[100]); err != nil {
+		t.Fatal(err)
+	}
+	middle := (offsets[1500]/blockSize + 1) * blockSize // 大记录的中间片段
+	for _, offset := range []int64{-1, offsets[5] + 1, offsets[5] + headerSize, middle, end + 1} {
+		if err := it.SeekTo(offset); !errors.Is(err, ErrInvalidOffset) {
+			t.Fatalf("SeekTo(%d): got %v, want ErrInvalidOffset", offset, err)
+		}
+	}
+	if got := readRest(t, it); !sameRecords(got, records[100:]) {
+		t.Fatalf("after rejected seeks: got %d records, want %d", len(got), len(records)-100)
+	}
+}
+
+func TestWriteBatchLimit(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "wal")
+	w, err := OpenWithOptions(path, Options{MaxBatchSize: 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	// 键和值共100字节，恰好等于上限
+	atLimit := []Record{
+		{Type: TypePut, Key: []byte("k1"), Value: bytes.Repeat([]byte("a"), 48)},
+		{Type: TypePut, Key: []byte("k2"), Value: bytes.Repeat([]byte("b"), 48)},
+	}
+	if err := w.WriteBatch(atLimit); err != nil {
+		t.Fatalf("batch at the limit: %v", err)
+	}
+
+	// 多一个字节即拒绝整个批量，不写入任何记录
+	end := w.End()
+	overLimit := append(atLimit, Record{Type: TypeDelete, Key: []byte("k")})
+	if err := w.WriteBatch(overLimit); !errors.Is(err, ErrBatchTooLarge) {
+		t.Fatalf("batch over the limit: got %v, want ErrBatchTooLarge", err)
+	}
+	if w.End() != end {
+		t.Fatalf("rejected batch wrote %d bytes", w.End()-end)
+	}
+	if got := readAll(t, w); !sameRecords(got, atLimit) {
+		t.Fatalf("got %d records, want %d", len(got), len(atLimit))
+	}
+
+	// 默认上限
+	w2, err := Open(filepath.Join(t.TempDir(), "wal"), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w2.Close()
+	if w2.maxBatchSize != DefaultMaxBatchSize {
+		t.Fatalf("default limit %d, want %d", w2.maxBatchSize, DefaultMaxBatchSize)
+	}
+}
+
+// 每次最多写入max字节的Writer，max为0时什么也不写
+type shortWriter struct {
+	buf   bytes.Buffer
+	max   int
+	calls int
+}
+
+func (s *shortWriter) Write(p []byte) (int, error) {
+	s.calls++
+	if len(p) > s.max {
+		p = p[:s.max]
+	}
+	return s.buf.Write(p)
+}
+
+func TestWriteFullShortWrites(t *testing.T) {
+	// 用WAL编码的片段作为写入的数据
+	path := filepath.Join(t.TempDir(), "wal")
+	w, err := Open(path, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	records := sequentialRecords(50)
+	writeRecords(t, w, records)
+	w.Close()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 部分写入时继续写入剩余的数据，直到全部写完
+	short := &shortWriter{max: 7}
+	if err := writeFull(short, data); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(short.buf.Bytes(), data) {
+		t.Fatal("short writes produced different bytes")
+	}
+	if want := (len(data) + 6) / 7; short.calls != want {
+		t.Fatalf("got %d calls, want %d", short.calls, want)
+	}
+
+	// 写入的数据仍然是完整的日志
+	copyPath := filepath.Join(t.TempDir(), "copy")
+	if err := os.WriteFile(copyPath, short.buf.Bytes(), 0666); err != nil {
+		t.Fatal(err)
+	}
+	w, err = Open(copyPath, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	if got := readAll(t, w); !sameRecords(got, records) {
+		t.Fatalf("got %d records, want %d", len(got), len(records))
+	}
+
+	// 无法继续写入时返回错误而不是一直重试
+	if err := writeFull(&shortWriter{max: 0}, data); err != io.ErrShortWrite {
+		t.Fatalf("got %v, want io.ErrShortWrite", err)
+	}
+}
+
+// 写入填充记录，使日志末尾位于距离块末尾distance字节处
+func positionBeforeBlockEnd(t *testing.T, w *WAL, distance int64) {
+	t.Helper()
+
+	// 键为1字节、值不超过127字节的填充记录共占headerSize+4+值长度字节
+	const overhead = headerSize + 4
+	target := (w.End()/blockSize+1)*blockSize - distance
+	for w.End() != target {
+		gap := target - w.End()
+		if gap < overhead {
+			target += blockSize
+			continue
+		}
+		value := gap - overhead
+		if value > 127 {
+			value = 127
+		}
+		if err := w.Write(Record{Type: TypePut, Key: []byte("f"), Value: make([]byte, value)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestEntrySize(t *testing.T) {
+	w, err := OpenWithOptions(filepath.Join(t.TempDir(), "wal"), Options{WriteBufferSize: 1 << 20})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	sizes := [][2]int{{0, 0}, {1, 0}, {16, 100}, {16, 1000}, {200, 127}, {8, blockSize - 30}, {32, 3 * blockSize}}
+	for _, size := range sizes {
+		bound := EntrySize(size[0], size[1])
+		record := Record{Type: TypePut, Key: bytes.Repeat([]byte("k"), size[0]), Value: bytes.Repeat([]byte("v"), size[1])}
+
+		// 从块末尾附近的不同位置开始写入，包括需要填充和记录被切开的情况
+		worst := int64(0)
+		for distance := int64(0); distance <= 64; distance++ {
+			positionBeforeBlockEnd(t, w, distance)
+			start := w.End()
+			if err := w.Write(record); err != nil {
+				t.Fatal(err)
+			}
+			actual := w.End() - start
+			if actual > bound || actual < bound-headerSize {
+				t.Fatalf("key %d value %d at %d bytes before a block end: wrote %d bytes, EntrySize %d",
+					size[0], size[1], distance, actual, bound)
+			}
+			if actual > worst {
+				worst = actual
+			}
+		}
+		// 上界是可以达到的
+		if worst != bound {
+			t.Errorf("key %d value %d: largest write %d, EntrySize %d", size[0], size[1], worst, bound)
+		}
+	}
+}
+
+func TestMixedChecksums(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "wal")
+	records := sequentialRecords(600)
+
+	// 前一半使用CRC32写入，重新打开后使用CRC32C继续写入
+	var offsets []int64
+	for i, checksum := range []byte{ChecksumCRC32, ChecksumCRC32C} {
+		w, err := OpenWithOptions(path, Options{Checksum: checksum})
+		if err != nil {
+			t.Fatal(err)
+		}
+		offsets = append(offsets, writeRecords(t, w, records[i*300:(i+1)*300])...)
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if algo := data[offsets[0]+6] >> 4; algo != ChecksumCRC32 {
+		t.Fatalf("first record uses checksum %d, want CRC32", algo)
+	}
+	if algo := data[offsets[300]+6] >> 4; algo != ChecksumCRC32C {
+		t.Fatalf("record 300 uses checksum %d, want CRC32C", algo)
+	}
+
+	// 每个片段按写入时的算法校验
+	w, err := Open(path, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := readAll(t, w); !sameRecords(got, records) {
+		t.Fatalf("got %d records, want %d", len(got), len(records))
+	}
+	w.Close()
+
+	// 两种算法都能发现损坏，第二处位于之后的块中，不会被第一处之后的重新同步跳过
+	second := sort.Search(len(offsets), func(i int) bool { return offsets[i] >= blockSize })
+	if second < 300 {
+		t.Fatalf("record %d in block 1 was written with CRC32", second)
+	}
+	for _, i := range []int{100, second} {
+		flipByte(t, path, offsets[i]+headerSize+2)
+	}
+	w, err = Open(path, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	var corrupted []int64
+	w.Scan(func(record *Record, offset int64, err error) {
+		if err != nil {
+			if !errors.Is(err, ErrInvalidChecksum) {
+				t.Errorf("offset %d: got %v, want ErrInvalidChecksum", offset, err)
+			}
+			corrupted = append(corrupted, offset)
+		}
+	})
+	if len(corrupted) != 2 || corrupted[0] != offsets[100] || corrupted[1] != offsets[second] {
+		t.Fatalf("got corruptions at %v, want %d and %d", corrupted, offsets[100], offsets[second])
+	}
+}
+
+func TestUnknownChecksum(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "wal")
+	if _, err := OpenWithOptions(path, Options{Checksum: 7}); err != ErrUnknownChecksum {
+		t.Fatalf("got %v, want ErrUnknownChecksum", err)
+	}
+
+	// 片段记录了未知的算法时视为校验失败
+	w, err := Open(path, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	offsets := writeRecords(t, w, sequentialRecords(3))
+	w.Close()
+
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	typeByte := make([]byte, 1)
+	f.ReadAt(typeByte, offsets[1]+6)
+	typeByte[0] = typeByte[0]&fragmentTypeMask | 7<<4
+	f.WriteAt(typeByte, offsets[1]+6)
+	f.Close()
+
+	w, err = Open(path, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	it, err := w.NewIterator()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer it.Close()
+	it.Next()
+	if _, err := it.Next(); !errors.Is(err, ErrInvalidChecksum) {
+		t.Fatalf("got %v, want ErrInvalidChecksum", err)
+	}
+}